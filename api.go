@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRequestBody limits the size of API request bodies
+const maxRequestBody = 1 << 20
+
+// maxConversationContext limits the characters of a stored transcript sent
+// with a new question; older messages are left out
+const maxConversationContext = 32000
+
+// API error codes
+const (
+	errCodeInvalidRequest = "invalid_request"
+	errCodeUnauthorized   = "unauthorized"
+	errCodeForbidden      = "forbidden"
+	errCodeNotFound       = "not_found"
+	errCodeRateLimited    = "rate_limited"
+	errCodeServerBusy     = "server_busy"
+	errCodeBudgetExceeded = "budget_exceeded"
+	errCodeTimeout        = "timeout"
+	errCodeInternal       = "internal_error"
+)
+
+// APIError is the body of every failed API response
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail describes an API error
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ChatTurn is one message of a conversation
+type ChatTurn struct {
+	Role    string `json:"role"` // "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// AskRequest is the body of POST /api/v1/ask
+type AskRequest struct {
+	Message string     `json:"message"`
+	History []ChatTurn `json:"history,omitempty"`
+}
+
+// AskResponse is the body of a successful POST /api/v1/ask
+type AskResponse struct {
+	Response string `json:"response"`
+	Command  string `json:"command,omitempty"`
+}
+
+// WriterRequest is the body of POST /api/v1/writer
+type WriterRequest struct {
+	Text     string `json:"text"`
+	Preset   string `json:"preset,omitempty"`
+	Tone     string `json:"tone,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	Lang     string `json:"lang,omitempty"`
+	Length   int    `json:"length,omitempty"`
+}
+
+// WriterResponse is the body of a successful POST /api/v1/writer
+type WriterResponse struct {
+	Text string `json:"text"`
+}
+
+// CommitMessageRequest is the body of POST /api/v1/commit-message
+type CommitMessageRequest struct {
+	Diff   string `json:"diff"`
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// CommitMessageResponse is the body of a successful POST /api/v1/commit-message
+type CommitMessageResponse struct {
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+}
+
+// HistoryResponse is the body of GET /api/v1/history
+type HistoryResponse struct {
+	Entries []HistoryEntry `json:"entries"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// ConversationRequest is the body of POST /api/v1/conversations and
+// PATCH /api/v1/conversations/{id}
+type ConversationRequest struct {
+	Title string `json:"title"`
+}
+
+// ConversationsResponse is the body of GET /api/v1/conversations
+type ConversationsResponse struct {
+	Conversations []Conversation `json:"conversations"`
+}
+
+// TranscriptResponse is the body of GET /api/v1/conversations/{id}
+type TranscriptResponse struct {
+	Conversation Conversation          `json:"conversation"`
+	Messages     []ConversationMessage `json:"messages"`
+}
+
+// MessageRequest is the body of POST /api/v1/conversations/{id}/messages
+type MessageRequest struct {
+	Message string `json:"message"`
+}
+
+// MessageResponse is the body of a successful POST /api/v1/conversations/{id}/messages:
+// the stored question and answer
+type MessageResponse struct {
+	Messages []ConversationMessage `json:"messages"`
+}
+
+// registerAPIRoutes mounts the versioned JSON API on the router. Handlers that
+// call the model are wrapped with limit.
+func registerAPIRoutes(r *mux.Router, limit func(http.HandlerFunc) http.HandlerFunc) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/ask", limit(apiAskHandler)).Methods("POST")
+	api.HandleFunc("/writer", limit(apiWriterHandler)).Methods("POST")
+	api.HandleFunc("/commit-message", limit(apiCommitMessageHandler)).Methods("POST")
+	api.HandleFunc("/history", apiHistoryHandler).Methods("GET")
+	api.HandleFunc("/conversations", apiListConversationsHandler).Methods("GET")
+	api.HandleFunc("/conversations", apiCreateConversationHandler).Methods("POST")
+	api.HandleFunc("/conversations/{id:[0-9]+}", apiTranscriptHandler).Methods("GET")
+	api.HandleFunc("/conversations/{id:[0-9]+}", apiRenameConversationHandler).Methods("PATCH")
+	api.HandleFunc("/conversations/{id:[0-9]+}", apiDeleteConversationHandler).Methods("DELETE")
+	api.HandleFunc("/conversations/{id:[0-9]+}/messages", limit(apiPostMessageHandler)).Methods("POST")
+	api.HandleFunc("/openapi.json", apiSpecHandler).Methods("GET")
+
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "no such endpoint: "+r.Method+" "+r.URL.Path)
+	})
+}
+
+// writeAPIJSON encodes v as the JSON response body
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		// The status line is already sent, so the client only sees a truncated body
+		log.Printf("Error encoding API response: %v", err)
+	}
+}
+
+// writeAPIError sends an error body with a machine-readable code
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
+
+// writeModelError reports a failed model call. Timeouts get a 504; nothing is
+// written when the client has gone away.
+func writeModelError(w http.ResponseWriter, r *http.Request, err error) {
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		writeAPIError(w, http.StatusGatewayTimeout, errCodeTimeout, "the model did not answer in time")
+	case context.Canceled:
+	default:
+		writeAPIError(w, http.StatusBadGateway, errCodeInternal, err.Error())
+	}
+}
+
+// decodeAPIRequest decodes a JSON request body, writing an error response and
+// returning false if it is invalid
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, errCodeInvalidRequest, "request body is too large")
+		} else {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "invalid JSON body: "+err.Error())
+		}
+		return false
+	}
+	return true
+}
+
+// formatHistoryPrompt prepends the previous turns of a conversation to a question
+func formatHistoryPrompt(history []ChatTurn, message string) string {
+	var b strings.Builder
+	b.WriteString("You are an AI assistant. ")
+	if len(history) > 0 {
+		b.WriteString("Previous conversation:\n")
+		for _, turn := range history {
+			if turn.Role == "assistant" {
+				b.WriteString("Answer: " + turn.Content + "\n\n")
+			} else {
+				b.WriteString("Question: " + turn.Content + "\n")
+			}
+		}
+	}
+	b.WriteString("New question: " + message)
+	return b.String()
+}
+
+func apiAskHandler(w http.ResponseWriter, r *http.Request) {
+	var request AskRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "message is required")
+		return
+	}
+
+	ai, err := AskQueryContext(r.Context(), formatHistoryPrompt(request.History, request.Message), nil)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, AskResponse{Response: ai.Response, Command: ai.Command})
+}
+
+func apiWriterHandler(w http.ResponseWriter, r *http.Request) {
+	var request WriterRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Text) == "" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "text is required")
+		return
+	}
+
+	var opts WriterOptions
+	if request.Preset != "" {
+		preset, err := loadWriterPreset(request.Preset)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+			return
+		}
+		opts = preset
+	}
+	for _, field := range []struct {
+		value  string
+		target *string
+	}{
+		{request.Tone, &opts.Tone},
+		{request.Format, &opts.Format},
+		{request.Audience, &opts.Audience},
+		{request.Lang, &opts.Lang},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if request.Length > 0 {
+		opts.Length = request.Length
+	}
+	if err := opts.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	text, err := extractGeminiTextContext(r.Context(), request.Text, opts)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, WriterResponse{Text: text})
+}
+
+func apiCommitMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var request CommitMessageRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+
+	files := diffFiles(request.Diff)
+	if len(files) == 0 {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "diff must be the output of git diff")
+		return
+	}
+
+	message, err := CommitMessageForDiff(r.Context(), files, request.Diff, request.Prompt)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, CommitMessageResponse{Message: message, Files: files})
+}
+
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 50, 0
+	for name, target := range map[string]*int{"limit": &limit, "offset": &offset} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, name+" must be a non-negative integer")
+			return
+		}
+		*target = n
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	entries, err := storage.ListCommands(limit, offset)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, HistoryResponse{Entries: entries, Limit: limit, Offset: offset})
+}
+
+// openAPIStorage opens the database, writing an error response on failure
+func openAPIStorage(w http.ResponseWriter) (*Storage, bool) {
+	storage, err := NewStorage()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return nil, false
+	}
+	return storage, true
+}
+
+// writeStorageError reports a storage failure, mapping unknown conversations to 404
+func writeStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConversationNotFound) {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+}
+
+// conversationID returns the conversation ID from the request path
+func conversationID(r *http.Request) int64 {
+	// The route only matches digits, so parsing can only fail on overflow
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	return id
+}
+
+func apiListConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	conversations, err := storage.ListConversations()
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, ConversationsResponse{Conversations: conversations})
+}
+
+func apiCreateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var request ConversationRequest
+	if r.ContentLength != 0 && !decodeAPIRequest(w, r, &request) {
+		return
+	}
+
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	conversation, err := storage.CreateConversation(request.Title)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, conversation)
+}
+
+func apiTranscriptHandler(w http.ResponseWriter, r *http.Request) {
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	id := conversationID(r)
+	conversation, err := storage.GetConversation(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	messages, err := storage.ConversationMessages(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, TranscriptResponse{Conversation: conversation, Messages: messages})
+}
+
+func apiRenameConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var request ConversationRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Title) == "" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "title is required")
+		return
+	}
+
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	conversation, err := storage.RenameConversation(conversationID(r), request.Title)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, conversation)
+}
+
+func apiDeleteConversationHandler(w http.ResponseWriter, r *http.Request) {
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	if err := storage.DeleteConversation(conversationID(r)); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// conversationLock is the lock of a conversation, a channel with room for one
+// token, and the number of requests holding or waiting for it
+type conversationLock struct {
+	token chan struct{}
+	users int
+}
+
+// conversationLocks holds the locks of the conversations in use. A lock is
+// dropped once no request holds or waits for it.
+var (
+	conversationLocksMu sync.Mutex
+	conversationLocks   = make(map[int64]*conversationLock)
+)
+
+// lockConversation waits until no other question is being asked in a
+// conversation, so that each answer sees the turns before it. It returns the
+// function releasing the lock.
+func lockConversation(ctx context.Context, id int64) (func(), error) {
+	conversationLocksMu.Lock()
+	lock, ok := conversationLocks[id]
+	if !ok {
+		lock = &conversationLock{token: make(chan struct{}, 1)}
+		conversationLocks[id] = lock
+	}
+	lock.users++
+	conversationLocksMu.Unlock()
+
+	done := func() {
+		conversationLocksMu.Lock()
+		defer conversationLocksMu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(conversationLocks, id)
+		}
+	}
+
+	select {
+	case lock.token <- struct{}{}:
+		return func() {
+			<-lock.token
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
+
+// recentTurns returns the latest turns of a transcript that fit in limit characters
+func recentTurns(history []ChatTurn, limit int) []ChatTurn {
+	start, size := len(history), 0
+	for start > 0 && size+len(history[start-1].Content) <= limit {
+		start--
+		size += len(history[start].Content)
+	}
+	return history[start:]
+}
+
+// apiPostMessageHandler asks a question in the context of the stored transcript
+// and appends both the question and the answer to the conversation. Questions
+// to the same conversation are answered one at a time.
+func apiPostMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var request MessageRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+	if strings.TrimSpace(request.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, "message is required")
+		return
+	}
+
+	storage, ok := openAPIStorage(w)
+	if !ok {
+		return
+	}
+	defer storage.Close()
+
+	id := conversationID(r)
+	unlock, err := lockConversation(r.Context(), id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+	defer unlock()
+
+	if _, err := storage.GetConversation(id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	transcript, err := storage.ConversationMessages(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	history := make([]ChatTurn, len(transcript))
+	for i, message := range transcript {
+		history[i] = ChatTurn{Role: message.Role, Content: message.Content}
+	}
+
+	ai, err := AskQueryContext(r.Context(), formatHistoryPrompt(recentTurns(history, maxConversationContext), request.Message), nil)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	messages, err := storage.AddConversationTurn(id, request.Message, ai)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, MessageResponse{Messages: messages})
+}
+
+// apiSpecHandler serves the OpenAPI description of the API embedded in the binary
+func apiSpecHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := fs.ReadFile(getEmbeddedWebFS(), "openapi.json")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "OpenAPI spec is missing from the binary")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
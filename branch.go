@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var branchSystemPrompt = `You name git branches.
+Suggest a short branch name for the work described, in the form <type>/<short-kebab-case-summary>,
+where type is one of feat, fix, refactor, docs, test, chore. Keep the summary under five words.
+If the description contains a ticket key such as ABC-123, put it right after the slash,
+e.g. feat/ABC-123-export-csv.`
+
+var invalidBranchChars = regexp.MustCompile(`[^a-zA-Z0-9/._-]+`)
+
+// BranchCmd represents the branch name suggestion command
+var BranchCmd = &cobra.Command{
+	Use:   "branch [ticket description]",
+	Short: "Suggest a branch name from the current changes or a ticket description",
+	Long: `Suggests a git branch name. With a ticket description as argument the name is based on
+it, otherwise on the uncommitted changes. Use --create to create and switch to the branch.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		create, _ := cmd.Flags().GetBool("create")
+
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		var query string
+		if len(args) > 0 {
+			query = "Ticket description:\n" + strings.Join(args, " ")
+		} else {
+			changedFiles, diff, err := GetDiff(path, "HEAD")
+			if err != nil {
+				return err
+			}
+			if len(changedFiles) == 0 {
+				return fmt.Errorf("there are no changes to name a branch after, pass a ticket description instead")
+			}
+			query = fmt.Sprintf("Changed files:\n%s\n\nDiff:\n%s",
+				strings.Join(changedFiles, "\n"),
+				limitDiffSize(diff, 4000))
+		}
+
+		branch, err := SuggestBranchName(path, query)
+		if err != nil {
+			return err
+		}
+
+		if !create {
+			fmt.Println(branch)
+			return nil
+		}
+
+		if _, err := GitOutput(path, "switch", "-c", branch); err != nil {
+			return err
+		}
+		color.Green("Switched to a new branch '%s'", branch)
+		return nil
+	},
+}
+
+func init() {
+	BranchCmd.Flags().BoolP("create", "c", false, "Create and switch to the suggested branch")
+	BranchCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// SuggestBranchName asks the AI for a branch name and makes sure git accepts it
+func SuggestBranchName(path, query string) (string, error) {
+	schema := sapiens.Schema{
+		Type: "object",
+		Properties: map[string]sapiens.Schema{
+			"branch": {
+				Type:        "string",
+				Description: "The suggested branch name",
+			},
+		},
+		Required: []string{"branch"},
+	}
+
+	fields, err := AskStructured(query, branchSystemPrompt, schema)
+	if err != nil {
+		return "", err
+	}
+
+	branch := invalidBranchChars.ReplaceAllString(strings.TrimSpace(stringField(fields, "branch")), "-")
+	branch = strings.Trim(branch, "-/.")
+	if branch == "" {
+		return "", fmt.Errorf("model did not suggest a branch name")
+	}
+
+	if _, err := GitOutput(path, "check-ref-format", "--branch", branch); err != nil {
+		return "", fmt.Errorf("suggested branch name %q is not valid: %w", branch, err)
+	}
+	return branch, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// TokenUsage counts the tokens used by one or more model calls
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	CachedTokens     int `json:"cached_tokens"` // prompt tokens the provider read from its cache, part of PromptTokens
+	SavedTokens      int `json:"saved_tokens"`  // answered from the response cache at no cost
+}
+
+// Add adds the usage of another call
+func (u *TokenUsage) Add(other TokenUsage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.CachedTokens += other.CachedTokens
+	u.SavedTokens += other.SavedTokens
+}
+
+// Cost returns the price of the usage in US dollars. Cached prompt tokens are
+// charged at the cached price, or at the prompt price when it has none.
+func (p ModelPrice) Cost(usage TokenUsage) float64 {
+	cached := min(usage.CachedTokens, usage.PromptTokens)
+	cachedPrice := p.Cached
+	if cachedPrice == 0 {
+		cachedPrice = p.Prompt
+	}
+	return (float64(usage.PromptTokens-cached)*p.Prompt + float64(cached)*cachedPrice +
+		float64(usage.CompletionTokens)*p.Completion) / 1e6
+}
+
+// usageMeter sums the token usage of the model calls made with a context.
+// Usage is also added to the meter of the enclosing context, if any.
+type usageMeter struct {
+	mu     sync.Mutex
+	usage  TokenUsage
+	parent *usageMeter
+}
+
+type usageMeterKey struct{}
+
+// withUsageMeter returns a context whose model calls are counted by the meter
+func withUsageMeter(ctx context.Context) (context.Context, *usageMeter) {
+	parent, _ := ctx.Value(usageMeterKey{}).(*usageMeter)
+	meter := &usageMeter{parent: parent}
+	return context.WithValue(ctx, usageMeterKey{}, meter), meter
+}
+
+// recordUsage adds usage to the meters of the context
+func recordUsage(ctx context.Context, usage TokenUsage) {
+	meter, _ := ctx.Value(usageMeterKey{}).(*usageMeter)
+	for ; meter != nil; meter = meter.parent {
+		meter.mu.Lock()
+		meter.usage.Add(usage)
+		meter.mu.Unlock()
+	}
+}
+
+// Usage returns the usage counted so far
+func (m *usageMeter) Usage() TokenUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
+
+// WebSpend returns the tokens and cost spent by the web server on a day
+func (s *Storage) WebSpend(day string) (int, float64, error) {
+	var tokens int
+	var cost float64
+	err := s.db.QueryRow("SELECT COALESCE(SUM(tokens), 0), COALESCE(SUM(cost), 0) FROM web_spend WHERE day = ?", day).
+		Scan(&tokens, &cost)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read spend: %w", err)
+	}
+	return tokens, cost, nil
+}
+
+// AddWebSpend adds tokens and cost to the spend of a day
+func (s *Storage) AddWebSpend(day string, tokens int, cost float64) error {
+	_, err := s.db.Exec(`INSERT INTO web_spend (day, tokens, cost) VALUES (?, ?, ?)
+		ON CONFLICT(day) DO UPDATE SET tokens = tokens + excluded.tokens, cost = cost + excluded.cost`,
+		day, tokens, cost)
+	if err != nil {
+		return fmt.Errorf("failed to record spend: %w", err)
+	}
+	return nil
+}
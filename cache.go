@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// defaultCacheTTL is how long cached responses are used when the config sets no ttl
+const defaultCacheTTL = 24 * time.Hour
+
+// noCache is set by the --no-cache flag
+var noCache bool
+
+// CacheCmd manages the response cache
+var CacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Show or clear the response cache",
+	Long: `Show or clear the response cache.
+
+The cache is off unless cache.enabled is set in ~/.gema/config.yaml. It answers
+repeated calls with the same model, system prompt, input and attachments from
+~/.gema/gema.db instead of asking the model again. Pass --no-cache to any command
+to ask the model again and refresh the cached response.`,
+}
+
+// CacheStatsCmd shows what the cache holds and saved
+var CacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size of the response cache and what it saved",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		storage, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		ttl := config.Cache.ttl()
+		stats, err := storage.ResponseCacheStats(time.Now().Add(-ttl))
+		if err != nil {
+			return err
+		}
+
+		if config.Cache.Enabled {
+			color.New(color.FgGreen, color.Bold).Printf("The cache is on, responses are kept for %s\n", ttl)
+		} else {
+			color.New(color.FgYellow, color.Bold).Println("The cache is off, set cache.enabled in ~/.gema/config.yaml to turn it on")
+		}
+		fmt.Printf("Entries: %d (%d expired)\n", stats.Entries, stats.Expired)
+		fmt.Printf("Size:    %.1f KB\n", float64(stats.Bytes)/1024)
+		fmt.Printf("Hits:    %d\n", stats.Hits)
+		fmt.Printf("Saved:   %d tokens", stats.Saved.TotalTokens)
+		if cost, priced := stats.savedCost(config.Prices); priced {
+			fmt.Printf(", $%.4f", cost)
+		}
+		fmt.Println()
+		return nil
+	},
+}
+
+// CacheClearCmd empties the cache
+var CacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete the cached responses",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		expired, _ := cmd.Flags().GetBool("expired")
+
+		storage, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		before := time.Now()
+		if expired {
+			config, err := LoadConfig()
+			if err != nil {
+				return err
+			}
+			before = before.Add(-config.Cache.ttl())
+		}
+		deleted, err := storage.ClearResponseCache(before)
+		if err != nil {
+			return err
+		}
+		color.New(color.FgGreen, color.Bold).Printf("✓ Deleted %d cached responses\n", deleted)
+		return nil
+	},
+}
+
+func init() {
+	CacheClearCmd.Flags().Bool("expired", false, "Only delete the responses older than the ttl")
+	CacheCmd.AddCommand(CacheStatsCmd, CacheClearCmd)
+}
+
+// ttl returns how long cached responses are used
+func (c CacheConfig) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return defaultCacheTTL
+	}
+	return c.TTL
+}
+
+// modelCall describes a call to the model, as far as the response cache is concerned
+type modelCall struct {
+	System      string   // system prompt
+	Format      string   // what shape the response must have, e.g. its schema
+	Input       string   // the query
+	Attachments [][]byte // images sent with the query
+	Tools       []string // names of the tools the agent can call
+}
+
+// cacheKey hashes everything that determines the response of a call
+func (c modelCall) cacheKey(provider, model string) string {
+	h := sha256.New()
+	parts := append([][]byte{[]byte(provider), []byte(model), []byte(c.System), []byte(c.Format), []byte(c.Input)}, c.Attachments...)
+	parts = append(parts, []byte(strings.Join(c.Tools, "\x00")))
+	for _, part := range parts {
+		binary.Write(h, binary.LittleEndian, uint64(len(part))) // keeps "ab"+"c" apart from "a"+"bc"
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedAgentResponse is how an agent response is stored in the cache
+type cachedAgentResponse struct {
+	Content    string      `json:"content"`
+	Structured interface{} `json:"structured,omitempty"`
+}
+
+type noCacheKey struct{}
+
+// withoutCache returns a context whose model calls don't use cached responses.
+// Their responses still replace the cached ones.
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// toolCallsKey holds the flag set when the agent of a context calls a tool
+type toolCallsKey struct{}
+
+// withToolCallRecord returns a context whose agent notes its tool calls with
+// noteToolCall, so that responses depending on a tool are not cached
+func withToolCallRecord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, toolCallsKey{}, new(atomic.Bool))
+}
+
+// noteToolCall records that the agent of ctx called a tool
+func noteToolCall(ctx context.Context) {
+	if called, ok := ctx.Value(toolCallsKey{}).(*atomic.Bool); ok {
+		called.Store(true)
+	}
+}
+
+// toolCalled reports whether the agent of ctx called a tool
+func toolCalled(ctx context.Context) bool {
+	called, ok := ctx.Value(toolCallsKey{}).(*atomic.Bool)
+	return ok && called.Load()
+}
+
+// cacheSkipped reports whether the calls of a context must ask the model even
+// if the cache holds a response
+func cacheSkipped(ctx context.Context) bool {
+	return noCache || ctx.Value(noCacheKey{}) != nil
+}
+
+// responseCacheTTL returns how long cached responses are used, or false when
+// the cache is off
+func responseCacheTTL() (time.Duration, bool) {
+	config, err := LoadConfig()
+	if err != nil || !config.Cache.Enabled {
+		return 0, false
+	}
+	return config.Cache.ttl(), true
+}
+
+// lookupCachedResponse returns the cached response for key, if there is one
+// younger than ttl, with the usage it cost, and reports the hit
+func lookupCachedResponse(ctx context.Context, key string, ttl time.Duration) (*sapiens.Response, TokenUsage, bool) {
+	storage, err := NewStorage()
+	if err != nil {
+		return nil, TokenUsage{}, false
+	}
+	defer storage.Close()
+
+	stored, usage, created, found, err := storage.CachedResponse(key, time.Now().Add(-ttl))
+	if err != nil {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: failed to read the response cache: %v\n", err)
+		return nil, TokenUsage{}, false
+	}
+	if !found {
+		return nil, TokenUsage{}, false
+	}
+
+	var cached cachedAgentResponse
+	if err := json.Unmarshal([]byte(stored), &cached); err != nil {
+		return nil, TokenUsage{}, false
+	}
+	reportCacheHit(ctx, time.Since(created))
+	return &sapiens.Response{Content: cached.Content, Structured: cached.Structured}, usage, true
+}
+
+// cacheResponse stores the response of a call in the cache
+func cacheResponse(key, model string, response *sapiens.Response, usage TokenUsage) {
+	encoded, err := json.Marshal(cachedAgentResponse{Content: response.Content, Structured: response.Structured})
+	if err != nil {
+		return
+	}
+
+	storage, err := NewStorage()
+	if err == nil {
+		defer storage.Close()
+		err = storage.StoreCachedResponse(key, model, string(encoded), usage)
+	}
+	if err != nil {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: failed to cache the response: %v\n", err)
+	}
+}
+
+type cacheHitReporterKey struct{}
+
+// withCacheHitReporter returns a context whose cache hits are reported to report
+// instead of on the terminal
+func withCacheHitReporter(ctx context.Context, report func(age time.Duration)) context.Context {
+	return context.WithValue(ctx, cacheHitReporterKey{}, report)
+}
+
+// reportCacheHit tells the user that a response came from the cache
+func reportCacheHit(ctx context.Context, age time.Duration) {
+	if report, ok := ctx.Value(cacheHitReporterKey{}).(func(time.Duration)); ok {
+		report(age)
+		return
+	}
+
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+	color.New(color.FgHiBlack).Fprintf(os.Stderr, "\rUsing a response cached %s ago, pass --no-cache to ask again\n", age.Round(time.Second))
+}
+
+// webCache lets web clients skip the cache with Cache-Control: no-cache and
+// marks the responses served from it with X-Gema-Cache: hit
+func webCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+			ctx = withoutCache(ctx)
+		}
+		ctx = withCacheHitReporter(ctx, func(age time.Duration) {
+			setCacheHitHeaders(w, age)
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// setCacheHitHeaders marks a response as served from the cache
+func setCacheHitHeaders(w http.ResponseWriter, age time.Duration) {
+	w.Header().Set("X-Gema-Cache", "hit")
+	w.Header().Set("Age", fmt.Sprint(int(age.Seconds())))
+}
+
+// CachedResponse returns the response cached under key after since and the
+// usage it cost, and counts the hit
+func (s *Storage) CachedResponse(key string, since time.Time) (string, TokenUsage, time.Time, bool, error) {
+	var response string
+	var usage TokenUsage
+	var created int64
+	err := s.db.QueryRow("SELECT response, prompt_tokens, completion_tokens, created_at FROM response_cache WHERE key = ? AND created_at > ?", key, since.Unix()).
+		Scan(&response, &usage.PromptTokens, &usage.CompletionTokens, &created)
+	if err == sql.ErrNoRows {
+		return "", usage, time.Time{}, false, nil
+	}
+	if err != nil {
+		return "", usage, time.Time{}, false, err
+	}
+	if _, err := s.db.Exec("UPDATE response_cache SET hits = hits + 1 WHERE key = ?", key); err != nil {
+		return "", usage, time.Time{}, false, err
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return response, usage, time.Unix(created, 0), true, nil
+}
+
+// StoreCachedResponse caches a response and the usage it cost
+func (s *Storage) StoreCachedResponse(key, model, response string, usage TokenUsage) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO response_cache
+		(key, model, response, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		key, model, response, usage.PromptTokens, usage.CompletionTokens, time.Now().Unix())
+	return err
+}
+
+// ClearResponseCache deletes the responses cached before a time and returns how many there were
+func (s *Storage) ClearResponseCache(before time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM response_cache WHERE created_at <= ?", before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear the cache: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// ResponseCacheStats describes the content of the response cache
+type ResponseCacheStats struct {
+	Entries, Expired int
+	Bytes            int64
+	Hits             int
+	Saved            TokenUsage            // usage the hits did not spend
+	SavedByModel     map[string]TokenUsage // the same, by model
+}
+
+// ResponseCacheStats counts the cached responses, those cached before
+// expiredBefore being expired
+func (s *Storage) ResponseCacheStats(expiredBefore time.Time) (ResponseCacheStats, error) {
+	stats := ResponseCacheStats{SavedByModel: map[string]TokenUsage{}}
+	rows, err := s.db.Query(`SELECT model, COUNT(*), SUM(created_at <= ?), SUM(LENGTH(response)), SUM(hits),
+		SUM(hits * prompt_tokens), SUM(hits * completion_tokens) FROM response_cache GROUP BY model`, expiredBefore.Unix())
+	if err != nil {
+		return stats, fmt.Errorf("failed to read the cache: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var model string
+		var entries, expired, hits int
+		var bytes int64
+		var saved TokenUsage
+		if err := rows.Scan(&model, &entries, &expired, &bytes, &hits, &saved.PromptTokens, &saved.CompletionTokens); err != nil {
+			return stats, fmt.Errorf("failed to read the cache: %w", err)
+		}
+		saved.TotalTokens = saved.PromptTokens + saved.CompletionTokens
+		stats.Entries += entries
+		stats.Expired += expired
+		stats.Bytes += bytes
+		stats.Hits += hits
+		stats.Saved.Add(saved)
+		stats.SavedByModel[model] = saved
+	}
+	return stats, rows.Err()
+}
+
+// savedCost prices the usage saved by the cache. It is false when a model that
+// saved tokens has no price.
+func (s ResponseCacheStats) savedCost(prices map[string]ModelPrice) (float64, bool) {
+	var cost float64
+	for model, saved := range s.SavedByModel {
+		price, ok := prices[model]
+		if !ok && saved.TotalTokens > 0 {
+			return 0, false
+		}
+		cost += price.Cost(saved)
+	}
+	return cost, true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// ChangelogEntries holds the changelog lines of a release grouped by kind
+type ChangelogEntries struct {
+	Breaking []string `json:"breaking"`
+	Features []string `json:"features"`
+	Fixes    []string `json:"fixes"`
+	Internal []string `json:"internal"`
+}
+
+const changelogHeader = `# Changelog
+
+All notable changes to this project will be documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).
+`
+
+var changelogSystemPrompt = `You write release notes from git history.
+Group every user-visible change into exactly one of these lists:
+  "breaking": changes that require users to change their usage, configuration or integrations
+  "features": new functionality or notable improvements
+  "fixes":    bug fixes
+  "internal": refactoring, tests, CI, dependency bumps, documentation and other maintenance
+Write each entry as one concise sentence in the past tense from the user's point of view,
+using the commit diff to understand what actually changed. Merge commits that describe the
+same change into a single entry and end entries with the short commit hash in parentheses.
+
+Reply with a JSON object with the keys "breaking", "features", "fixes" and "internal",
+each an array of strings, and nothing else.`
+
+// ChangelogCmd represents the changelog command
+var ChangelogCmd = &cobra.Command{
+	Use:   "changelog <from>..<to>",
+	Short: "Generate a changelog or release notes from git history",
+	Long: `Groups the commits in a range (and their diffs) into Breaking changes, Features,
+Fixes and Internal using AI, and prints a Keep a Changelog section.
+If <to> is omitted HEAD is used, e.g. "ai changelog v1.2.0".
+
+Use --prepend to add the section to CHANGELOG.md, or --release-notes to write a file for
+"goreleaser release --release-notes <file>".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		version, _ := cmd.Flags().GetString("version")
+		prepend, _ := cmd.Flags().GetString("prepend")
+		releaseNotes, _ := cmd.Flags().GetString("release-notes")
+
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		if strings.Contains(args[0], "...") {
+			return fmt.Errorf("use <from>..<to>, the commits between the two sides of %s are not a release", args[0])
+		}
+		from, to, found := strings.Cut(args[0], "..")
+		if !found || to == "" {
+			to = "HEAD"
+		}
+		if version == "" {
+			version = to
+			if to == "HEAD" {
+				version = "Unreleased"
+			}
+		}
+
+		processingMsg := color.New(color.FgYellow).PrintFunc()
+		processingMsg(fmt.Sprintf("Summarizing commits in %s..%s...\n", from, to))
+
+		entries, err := GenerateChangelog(path, from, to)
+		if err != nil {
+			return err
+		}
+
+		date, err := GitOutput(path, "log", "-1", "--format=%cs", to)
+		if err != nil {
+			return err
+		}
+
+		section := formatChangelogSection(version, strings.TrimSpace(date), entries)
+
+		if releaseNotes != "" {
+			if err := os.WriteFile(releaseNotes, []byte(formatChangelogEntries(entries)), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", releaseNotes, err)
+			}
+			color.Green("Release notes written to %s", releaseNotes)
+		}
+
+		if prepend != "" {
+			if err := prependChangelog(prepend, section); err != nil {
+				return err
+			}
+			color.Green("Changelog section added to %s", prepend)
+		}
+
+		if releaseNotes == "" && prepend == "" {
+			fmt.Print(section)
+		}
+		return nil
+	},
+}
+
+func init() {
+	ChangelogCmd.Flags().String("version", "", "Version heading for the section (defaults to <to>, or Unreleased for HEAD)")
+	ChangelogCmd.Flags().String("prepend", "", "Prepend the section to a changelog file")
+	ChangelogCmd.Flags().Lookup("prepend").NoOptDefVal = "CHANGELOG.md"
+	ChangelogCmd.Flags().String("release-notes", "", "Write goreleaser-compatible release notes to a file")
+	ChangelogCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// GenerateChangelog groups the commits between from and to into changelog entries
+func GenerateChangelog(path, from, to string) (ChangelogEntries, error) {
+	var entries ChangelogEntries
+
+	history, err := GitOutput(path, "log", "--no-merges", "--format=%h", from+".."+to)
+	if err != nil {
+		return entries, err
+	}
+
+	hashes := strings.Fields(history)
+	if len(hashes) == 0 {
+		return entries, fmt.Errorf("no commits found in %s..%s", from, to)
+	}
+
+	// Keep the whole prompt within a reasonable size by sharing the budget between commits
+	perCommit := 40000 / len(hashes)
+	if perCommit > 3000 {
+		perCommit = 3000
+	}
+
+	var query strings.Builder
+	for _, hash := range hashes {
+		show, err := GitOutput(path, "show", "--format=commit %h%n%s%n%n%b", "--stat", "--patch", hash)
+		if err != nil {
+			return entries, err
+		}
+		query.WriteString(limitDiffSize(show, perCommit))
+		query.WriteString("\n\n")
+	}
+
+	if err := AskJSON(query.String(), changelogSystemPrompt, &entries); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// formatChangelogEntries renders the grouped entries as Markdown sections
+func formatChangelogEntries(entries ChangelogEntries) string {
+	var b strings.Builder
+	groups := []struct {
+		title   string
+		entries []string
+	}{
+		{"Breaking Changes", entries.Breaking},
+		{"Features", entries.Features},
+		{"Fixes", entries.Fixes},
+		{"Internal", entries.Internal},
+	}
+
+	for _, group := range groups {
+		if len(group.entries) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s\n\n", group.title)
+		for _, entry := range group.entries {
+			fmt.Fprintf(&b, "- %s\n", strings.TrimSpace(strings.TrimPrefix(entry, "- ")))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// formatChangelogSection renders a Keep a Changelog release section
+func formatChangelogSection(version, date string, entries ChangelogEntries) string {
+	heading := fmt.Sprintf("## [%s] - %s", strings.TrimPrefix(version, "v"), date)
+	if version == "Unreleased" {
+		heading = "## [Unreleased]"
+	}
+	return heading + "\n\n" + formatChangelogEntries(entries)
+}
+
+// prependChangelog inserts section above the newest release of the changelog at
+// path, creating the file with the standard header if it does not exist
+func prependChangelog(path, section string) error {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	content := string(existing)
+	if strings.TrimSpace(content) == "" {
+		content = changelogHeader
+	}
+
+	// Insert before the first release heading, keeping the title and intro on top
+	insertAt := len(content)
+	if strings.HasPrefix(content, "## ") {
+		insertAt = 0
+	} else if index := strings.Index(content, "\n## "); index >= 0 {
+		insertAt = index + 1
+	} else if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+		insertAt = len(content)
+	}
+
+	if insertAt == len(content) {
+		section = "\n" + section
+	}
+
+	updated := content[:insertAt] + section + content[insertAt:]
+	if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -20,12 +23,38 @@ var MakeCmd = &cobra.Command{
 	RunE:    executeMakeCommand,
 }
 
+func init() {
+	MakeCmd.Flags().String("context", "", "Answer from the files of a directory indexed with ai index")
+	MakeCmd.Flags().Int("top-k", defaultTopK, "Number of indexed chunks given with --context")
+}
+
 func executeMakeCommand(cmd *cobra.Command, args []string) error {
 	query := strings.Join(args, " ")
+	contextDir, _ := cmd.Flags().GetString("context")
+	topK, _ := cmd.Flags().GetInt("top-k")
+
+	var sources []IndexChunk
+	if contextDir != "" {
+		chunks, err := retrieveContext(context.Background(), contextDir, query, topK)
+		if err != nil {
+			return err
+		}
+		sources = chunks
+		query = contextQuery(contextDir, query, chunks)
+	}
+
 	m := waitForResponse(model{query: query, loading: true})
 
 	fmt.Println(m.View())
 
+	if len(sources) > 0 {
+		color.New(color.FgBlue, color.Bold).Println("Sources:")
+		for i, chunk := range sources {
+			fmt.Printf("  [%d] %s (%.2f)\n", i+1, chunkCitation(contextDir, chunk), chunk.Score)
+		}
+		fmt.Println()
+	}
+
 	if m.command != "" {
 		var input string
 		color.New(color.FgYellow).Print("Run command (y for yes, n for no): ")
@@ -38,6 +67,9 @@ func executeMakeCommand(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// terminalMu keeps the loading animation from drawing over confirmation prompts
+var terminalMu sync.Mutex
+
 func waitForResponse(m model) model {
 	// Show animated loading dots
 	done := make(chan bool)
@@ -49,7 +81,9 @@ func waitForResponse(m model) model {
 			case <-done:
 				return
 			default:
+				terminalMu.Lock()
 				color.New(color.FgCyan).Printf("\rLoading %s", loadingChars[i%len(loadingChars)])
+				terminalMu.Unlock()
 				time.Sleep(100 * time.Millisecond)
 				i++
 			}
@@ -80,6 +114,16 @@ func (m model) View() string {
 	return fmt.Sprintf("%s\n%s\n%s%s\n", responseHeader, formattedResponse, commandHeader, commandText)
 }
 
+// stdinIsTerminal reports whether stdin is a terminal. It is false when stdin
+// cannot be inspected, e.g. because it is closed.
+func stdinIsTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
 func runCommand(command string) {
 	color.New(color.FgBlue).Printf("Executing: %s\n", command)
 	out, err := exec.Command("bash", "-c", command).Output()
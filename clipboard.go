@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Clipboard reads and writes the system clipboard
+type Clipboard interface {
+	Read() (string, error)
+	Write(text string) error
+}
+
+// clipboardBackend is the clipboard used by the CLI. When nil the backend is
+// detected from the platform and config on first use; tests can replace it.
+var clipboardBackend Clipboard
+
+// commandClipboard talks to the clipboard through external commands such as
+// pbcopy/pbpaste, wl-copy/wl-paste, xclip or xsel
+type commandClipboard struct {
+	read  []string
+	write []string
+}
+
+func (c commandClipboard) Read() (string, error) {
+	out, err := exec.Command(c.read[0], c.read[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read clipboard with %s: %w", c.read[0], err)
+	}
+	return string(out), nil
+}
+
+func (c commandClipboard) Write(text string) error {
+	cmd := exec.Command(c.write[0], c.write[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write clipboard with %s: %w", c.write[0], err)
+	}
+	return nil
+}
+
+// SystemClipboard returns the clipboard backend, detecting it if needed
+func SystemClipboard() (Clipboard, error) {
+	if clipboardBackend != nil {
+		return clipboardBackend, nil
+	}
+
+	backend, err := detectClipboard()
+	if err != nil {
+		return nil, err
+	}
+	clipboardBackend = backend
+	return backend, nil
+}
+
+// detectClipboard picks the clipboard commands from the config, or else from
+// the platform and the tools installed
+func detectClipboard() (Clipboard, error) {
+	if config, err := LoadConfig(); err == nil && config.Clipboard.Read != "" && config.Clipboard.Write != "" {
+		return commandClipboard{
+			read:  strings.Fields(config.Clipboard.Read),
+			write: strings.Fields(config.Clipboard.Write),
+		}, nil
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return commandClipboard{read: []string{"pbpaste"}, write: []string{"pbcopy"}}, nil
+	case "windows":
+		return commandClipboard{
+			read:  []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+			write: []string{"clip"},
+		}, nil
+	}
+
+	candidates := []commandClipboard{
+		{read: []string{"xclip", "-selection", "clipboard", "-o"}, write: []string{"xclip", "-selection", "clipboard", "-i"}},
+		{read: []string{"xsel", "--clipboard", "--output"}, write: []string{"xsel", "--clipboard", "--input"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		wayland := commandClipboard{read: []string{"wl-paste", "--no-newline"}, write: []string{"wl-copy"}}
+		candidates = append([]commandClipboard{wayland}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate.read[0]); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("no clipboard tool found, install wl-clipboard, xclip or xsel, or set clipboard.read and clipboard.write in ~/.gema/config.yaml")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fakeClipboard is an in-memory Clipboard
+type fakeClipboard struct {
+	text    string
+	readErr error
+	writes  int
+}
+
+func (c *fakeClipboard) Read() (string, error) {
+	return c.text, c.readErr
+}
+
+func (c *fakeClipboard) Write(text string) error {
+	c.text = text
+	c.writes++
+	return nil
+}
+
+// useFakeClipboard installs a fake clipboard holding text for the rest of the test
+func useFakeClipboard(t *testing.T, text string) *fakeClipboard {
+	t.Helper()
+	previous := clipboardBackend
+	fake := &fakeClipboard{text: text}
+	clipboardBackend = fake
+	t.Cleanup(func() { clipboardBackend = previous })
+	return fake
+}
+
+func TestClipboardRoundTrip(t *testing.T) {
+	fake := useFakeClipboard(t, "")
+
+	if err := PutTextOnClipboard("hello\nworld"); err != nil {
+		t.Fatalf("PutTextOnClipboard: %v", err)
+	}
+	got, err := GetTextFromClipboard()
+	if err != nil || got != "hello\nworld" {
+		t.Errorf("GetTextFromClipboard() = %q, %v", got, err)
+	}
+	if fake.writes != 1 {
+		t.Errorf("writes = %d, want 1", fake.writes)
+	}
+}
+
+func TestReviseClipboardLeavesClipboardOnError(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		readErr error
+		want    string
+	}{
+		{name: "empty clipboard", text: " \n\t", want: "does not contain any text"},
+		{name: "unreadable clipboard", readErr: errors.New("no display"), want: "no display"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeClipboard(t, tt.text)
+			fake.readErr = tt.readErr
+
+			err := reviseClipboard(WriterOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("reviseClipboard() error = %v, want %q", err, tt.want)
+			}
+			if fake.writes != 0 {
+				t.Errorf("the clipboard was written %d times", fake.writes)
+			}
+		})
+	}
+}
+
+func TestReviseClipboard(t *testing.T) {
+	previous := writerModel
+	t.Cleanup(func() { writerModel = previous })
+	var asked string
+	var askedOpts WriterOptions
+	writerModel = func(ctx context.Context, text string, opts WriterOptions) (string, error) {
+		asked, askedOpts = text, opts
+		return "The build is failing again.", nil
+	}
+
+	fake := useFakeClipboard(t, "hey, the build is broke again")
+	opts := WriterOptions{Tone: "friendly"}
+	if err := reviseClipboard(opts); err != nil {
+		t.Fatalf("reviseClipboard: %v", err)
+	}
+	if asked != "hey, the build is broke again" || askedOpts != opts {
+		t.Errorf("the model was asked to revise %q with %+v", asked, askedOpts)
+	}
+	if fake.text != "The build is failing again." || fake.writes != 1 {
+		t.Errorf("clipboard = %q after %d writes, want the revision", fake.text, fake.writes)
+	}
+}
+
+func TestReviseClipboardKeepsTextWhenTheModelFails(t *testing.T) {
+	previous := writerModel
+	t.Cleanup(func() { writerModel = previous })
+	writerModel = func(ctx context.Context, text string, opts WriterOptions) (string, error) {
+		return "", errors.New("quota exceeded")
+	}
+
+	fake := useFakeClipboard(t, "some text")
+	if err := reviseClipboard(WriterOptions{}); err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Errorf("reviseClipboard() error = %v, want the model error", err)
+	}
+	if fake.text != "some text" || fake.writes != 0 {
+		t.Errorf("clipboard = %q after %d writes, want it untouched", fake.text, fake.writes)
+	}
+}
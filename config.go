@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the user settings read from ~/.gema/config.yaml
+type Config struct {
+	Writer    WriterConfig            `yaml:"writer"`
+	Clipboard ClipboardConfig         `yaml:"clipboard"`
+	Web       WebConfig               `yaml:"web"`
+	Prices    map[string]ModelPrice   `yaml:"prices"` // keyed by model name
+	MCP       MCPConfig               `yaml:"mcp"`
+	Recipes   map[string]RecipeConfig `yaml:"recipes"` // keyed by command name
+	Cache     CacheConfig             `yaml:"cache"`
+}
+
+// CacheConfig turns on the response cache
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"` // how long responses are used, 24h by default
+}
+
+// RecipeConfig is a user-defined command that sends its input to the model
+// with its own system prompt, e.g. "ai sql ..."
+type RecipeConfig struct {
+	Short   string            `yaml:"short"` // shown in ai help
+	Aliases []string          `yaml:"aliases"`
+	System  string            `yaml:"system"` // Go template over the input, os, shell, cwd, date and vars
+	Output  map[string]string `yaml:"output"` // fields of the response and their descriptions
+	Result  string            `yaml:"result"` // field the action applies to, the only field or "result" by default
+	Model   string            `yaml:"model"`  // overrides GENAI_DEFAULT_MODEL
+	Action  string            `yaml:"action"` // print (the default), copy or run
+	Vars    map[string]string `yaml:"vars"`   // template variables, overridden with --var
+}
+
+// MCPConfig declares the MCP servers whose tools the assistant may use
+type MCPConfig struct {
+	Servers map[string]MCPServerConfig `yaml:"servers"` // keyed by a short name used to prefix the tools
+}
+
+// MCPServerConfig describes how to reach an MCP server and which of its tools
+// to use. Tool lists hold glob patterns such as "search_*".
+type MCPServerConfig struct {
+	Command     string            `yaml:"command"` // run the server and talk to it over stdio
+	Args        []string          `yaml:"args"`
+	Env         map[string]string `yaml:"env"`
+	URL         string            `yaml:"url"`          // or connect to a streamable HTTP server
+	Headers     map[string]string `yaml:"headers"`      // e.g. Authorization: "Bearer ${JIRA_TOKEN}"
+	Allow       []string          `yaml:"allow"`        // tools to use, all when empty
+	Deny        []string          `yaml:"deny"`         // tools never to use, even if allowed
+	AutoApprove []string          `yaml:"auto_approve"` // tools called without confirmation
+	Timeout     time.Duration     `yaml:"timeout"`      // per tool call, 1m by default
+}
+
+// WebConfig holds the limits of the web server. Zero values use the defaults.
+type WebConfig struct {
+	RateLimit float64       `yaml:"rate_limit"` // model requests per minute per client
+	Burst     int           `yaml:"burst"`      // requests a client may make at once
+	Workers   int           `yaml:"workers"`    // model requests running at the same time
+	Queue     int           `yaml:"queue"`      // requests waiting for a worker before 429s, -1 for none
+	Timeout   time.Duration `yaml:"timeout"`    // e.g. 90s or 2m
+	Budget    BudgetConfig  `yaml:"budget"`
+}
+
+// BudgetConfig caps what the web server may spend per day. Zero means no limit.
+type BudgetConfig struct {
+	DailyTokens int     `yaml:"daily_tokens"`
+	DailyCost   float64 `yaml:"daily_cost"` // in US dollars, needs a price for the model
+}
+
+// ModelPrice is the price of a model in US dollars per million tokens
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Cached     float64 `yaml:"cached"` // prompt tokens read from the provider's cache, the prompt price if unset
+	Completion float64 `yaml:"completion"`
+}
+
+// ClipboardConfig overrides the commands used to access the clipboard
+type ClipboardConfig struct {
+	Read  string `yaml:"read"`  // command printing the clipboard, e.g. "wl-paste --no-newline"
+	Write string `yaml:"write"` // command storing its stdin in the clipboard, e.g. "wl-copy"
+}
+
+// WriterConfig holds the settings of the writer command
+type WriterConfig struct {
+	// Presets are named combinations of writer options, used with --preset
+	Presets map[string]WriterOptions `yaml:"presets"`
+}
+
+// GemaDir returns the ~/.gema directory, creating it if needed
+func GemaDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	gemaDir := filepath.Join(homeDir, ".gema")
+	if err := os.MkdirAll(gemaDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", gemaDir, err)
+	}
+	return gemaDir, nil
+}
+
+// LoadConfig reads ~/.gema/config.yaml. A missing file yields an empty config.
+func LoadConfig() (*Config, error) {
+	gemaDir, err := GemaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	configPath := filepath.Join(gemaDir, "config.yaml")
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// errConversationNotFound is returned for operations on an unknown conversation
+var errConversationNotFound = errors.New("conversation not found")
+
+// conversationTitleLength is the length of titles derived from the first message
+const conversationTitleLength = 60
+
+// Conversation is a chat held in the web UI or through the API
+type Conversation struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConversationMessage is one turn of a conversation
+type ConversationMessage struct {
+	ID        int64     `json:"id"`
+	Role      string    `json:"role"` // "user" or "assistant"
+	Content   string    `json:"content"`
+	Command   string    `json:"command,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CreateConversation starts a new, empty conversation
+func (s *Storage) CreateConversation(title string) (Conversation, error) {
+	result, err := s.db.Exec("INSERT INTO conversations (title) VALUES (?)", strings.TrimSpace(title))
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return s.GetConversation(id)
+}
+
+// GetConversation returns a conversation without its messages
+func (s *Storage) GetConversation(id int64) (Conversation, error) {
+	var c Conversation
+	err := s.db.QueryRow("SELECT id, title, created_at, updated_at FROM conversations WHERE id = ?", id).
+		Scan(&c.ID, &c.Title, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, errConversationNotFound
+	}
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to read conversation: %w", err)
+	}
+	return c, nil
+}
+
+// ListConversations returns conversations, most recently active first
+func (s *Storage) ListConversations() ([]Conversation, error) {
+	rows, err := s.db.Query("SELECT id, title, created_at, updated_at FROM conversations ORDER BY updated_at DESC, id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.Title, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read conversation: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// RenameConversation changes the title of a conversation
+func (s *Storage) RenameConversation(id int64, title string) (Conversation, error) {
+	result, err := s.db.Exec("UPDATE conversations SET title = ? WHERE id = ?", strings.TrimSpace(title), id)
+	if err != nil {
+		return Conversation{}, fmt.Errorf("failed to rename conversation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return Conversation{}, errConversationNotFound
+	}
+	return s.GetConversation(id)
+}
+
+// DeleteConversation removes a conversation and its messages
+func (s *Storage) DeleteConversation(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM conversation_messages WHERE conversation_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM conversations WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errConversationNotFound
+	}
+	return tx.Commit()
+}
+
+// ConversationMessages returns the transcript of a conversation in order
+func (s *Storage) ConversationMessages(id int64) ([]ConversationMessage, error) {
+	rows, err := s.db.Query("SELECT id, role, content, command, timestamp FROM conversation_messages WHERE conversation_id = ? ORDER BY id", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ConversationMessage{}
+	for rows.Next() {
+		var m ConversationMessage
+		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &m.Command, &m.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// AddConversationTurn stores a question and its answer. A conversation without
+// a title is named after its first question. It fails with
+// errConversationNotFound if the conversation was deleted meanwhile.
+func (s *Storage) AddConversationTurn(id int64, question string, answer AiResponse) ([]ConversationMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store messages: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE conversations SET updated_at = CURRENT_TIMESTAMP, title = CASE WHEN title = '' THEN ? ELSE title END WHERE id = ?",
+		conversationTitle(question), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store messages: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errConversationNotFound
+	}
+
+	insert := "INSERT INTO conversation_messages (conversation_id, role, content, command) VALUES (?, ?, ?, ?)"
+	if _, err := tx.Exec(insert, id, "user", question, ""); err != nil {
+		return nil, fmt.Errorf("failed to store messages: %w", err)
+	}
+	if _, err := tx.Exec(insert, id, "assistant", answer.Response, answer.Command); err != nil {
+		return nil, fmt.Errorf("failed to store messages: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to store messages: %w", err)
+	}
+
+	messages, err := s.ConversationMessages(id)
+	if err != nil {
+		return nil, err
+	}
+	return messages[len(messages)-2:], nil
+}
+
+// conversationTitle shortens a question to its first line for use as a title
+func conversationTitle(question string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(question), "\n")
+	if utf8.RuneCountInString(title) <= conversationTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:conversationTitleLength-1])) + "…"
+}
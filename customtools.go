@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the custom tools
+const (
+	defaultToolTimeout   = 30 * time.Second
+	defaultToolMaxOutput = 16 * 1024
+)
+
+// customToolName matches the names a custom tool may have
+var customToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolsFile is the content of ~/.gema/tools.yaml
+type ToolsFile struct {
+	Tools []CustomTool `yaml:"tools"`
+}
+
+// CustomTool is a tool the assistant may call, backed by a shell command or an
+// HTTP request. Command, url and body are Go templates over the parameters.
+type CustomTool struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	Parameters  map[string]interface{} `yaml:"parameters"` // JSON schema of the arguments
+	Command     string                 `yaml:"command"`    // arguments are shell-quoted, e.g. du -sh {{.path}}
+	HTTP        *CustomToolHTTP        `yaml:"http"`
+	Confirm     string                 `yaml:"confirm"`    // always (the default) or never
+	Timeout     time.Duration          `yaml:"timeout"`    // 30s by default
+	MaxOutput   int                    `yaml:"max_output"` // in bytes, 16 KB by default
+}
+
+// CustomToolHTTP describes the request of an HTTP tool
+type CustomToolHTTP struct {
+	Method  string            `yaml:"method"`  // GET by default
+	URL     string            `yaml:"url"`     // arguments are URL-escaped
+	Headers map[string]string `yaml:"headers"` // environment variables are expanded
+	Body    string            `yaml:"body"`    // arguments are JSON-escaped; the arguments as JSON when empty, except for GET
+}
+
+var (
+	customToolsOnce sync.Once
+	customTools     []CustomTool
+)
+
+// loadCustomTools reads ~/.gema/tools.yaml the first time it is called. Invalid
+// tools are reported once and skipped.
+func loadCustomTools() []CustomTool {
+	customToolsOnce.Do(func() {
+		tools, err := readToolsFile()
+		if err != nil {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: custom tools are disabled: %v\n", err)
+			return
+		}
+
+		seen := map[string]bool{"get_system_info": true}
+		for _, tool := range tools {
+			if err := tool.validate(); err != nil {
+				color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: skipping custom tool %q: %v\n", tool.Name, err)
+				continue
+			}
+			if seen[tool.Name] {
+				color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: skipping custom tool %q: the name is already used\n", tool.Name)
+				continue
+			}
+			seen[tool.Name] = true
+			customTools = append(customTools, tool)
+		}
+	})
+	return customTools
+}
+
+// readToolsFile parses ~/.gema/tools.yaml. A missing file yields no tools.
+func readToolsFile() ([]CustomTool, error) {
+	gemaDir, err := GemaDir()
+	if err != nil {
+		return nil, err
+	}
+
+	toolsPath := filepath.Join(gemaDir, "tools.yaml")
+	data, err := os.ReadFile(toolsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", toolsPath, err)
+	}
+
+	var file ToolsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", toolsPath, err)
+	}
+	return file.Tools, nil
+}
+
+// validate checks a tool before it is offered to the model
+func (t CustomTool) validate() error {
+	if !customToolName.MatchString(t.Name) {
+		return fmt.Errorf("the name must be 1 to 64 letters, digits, _ or -")
+	}
+	if t.Description == "" {
+		return fmt.Errorf("a description is required")
+	}
+	if (t.Command == "") == (t.HTTP == nil) {
+		return fmt.Errorf("set either command or http")
+	}
+	if t.HTTP != nil && t.HTTP.URL == "" {
+		return fmt.Errorf("http needs a url")
+	}
+	if t.Confirm != "" && t.Confirm != "always" && t.Confirm != "never" {
+		return fmt.Errorf("confirm must be always or never, not %q", t.Confirm)
+	}
+
+	templates := []string{t.Command}
+	if t.HTTP != nil {
+		templates = append(templates, t.HTTP.URL, t.HTTP.Body)
+	}
+	for _, text := range templates {
+		if _, err := template.New(t.Name).Parse(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerCustomTools adds the tools of ~/.gema/tools.yaml to an agent and
+// returns their names
+func registerCustomTools(ctx context.Context, agent *sapiens.Agent) []string {
+	var names []string
+	for _, tool := range loadCustomTools() {
+		tool := tool
+		agentTool := sapiens.Tool{Name: tool.Name, Description: tool.Description}
+		setToolInputSchema(&agentTool, sapiensSchema(tool.Parameters))
+		agent.AddTools(agentTool)
+		agent.RegisterToolImplementation(tool.Name, func(params map[string]interface{}) (interface{}, error) {
+			noteToolCall(ctx)
+			return tool.call(ctx, params)
+		})
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// call runs the tool, after asking the user unless its policy says otherwise
+func (t CustomTool) call(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if t.Confirm != "never" {
+		confirm := toolConfirmer(ctx)
+		if confirm == nil {
+			return nil, fmt.Errorf("calling %s needs a confirmation that cannot be asked here, set confirm: never to allow it", t.Name)
+		}
+		if !confirm(t.Name, "tools.yaml", args) {
+			return nil, fmt.Errorf("the user declined the call to %s", t.Name)
+		}
+	}
+
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = defaultToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output string
+	var err error
+	if t.HTTP != nil {
+		output, err = t.request(ctx, args)
+	} else {
+		output, err = t.run(ctx, args)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %s", t.Name, timeout)
+	}
+	return output, err
+}
+
+// run renders the command template and runs it with bash
+func (t CustomTool) run(ctx context.Context, args map[string]interface{}) (string, error) {
+	command, err := t.render(t.Command, args, shellQuote)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", command)
+	cmd.WaitDelay = time.Second // don't wait for children still holding the output open
+	output := &cappedBuffer{limit: t.maxOutput() + 1}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err = cmd.Run()
+	text := t.limitOutput(output.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v\n%s", t.Name, err, text)
+	}
+	return text, nil
+}
+
+// request renders the HTTP templates and sends the request
+func (t CustomTool) request(ctx context.Context, args map[string]interface{}) (string, error) {
+	target, err := t.render(t.HTTP.URL, args, urlEscape)
+	if err != nil {
+		return "", err
+	}
+
+	method := strings.ToUpper(t.HTTP.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	switch {
+	case t.HTTP.Body != "":
+		rendered, err := t.render(t.HTTP.Body, args, jsonEscape)
+		if err != nil {
+			return "", err
+		}
+		body = strings.NewReader(rendered)
+	case method != http.MethodGet:
+		encoded, err := json.Marshal(args)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range t.HTTP.Headers {
+		req.Header.Set(key, os.ExpandEnv(value))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w", t.Name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(t.maxOutput())+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the response of %s: %w", t.Name, err)
+	}
+	text := t.limitOutput(data)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("%s failed: %s\n%s", t.Name, resp.Status, text)
+	}
+	return text, nil
+}
+
+func (t CustomTool) maxOutput() int {
+	if t.MaxOutput <= 0 {
+		return defaultToolMaxOutput
+	}
+	return t.MaxOutput
+}
+
+// limitOutput cuts the output of a tool to its size limit
+func (t CustomTool) limitOutput(output []byte) string {
+	if limit := t.maxOutput(); len(output) > limit {
+		return string(output[:limit]) + "\n... output truncated"
+	}
+	return string(output)
+}
+
+// cappedBuffer keeps the first limit bytes written to it and drops the rest
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// render executes a template over the arguments of a call, with each argument
+// passed through escape. Optional parameters the call leaves out are empty,
+// and a missing required argument is an error.
+func (t CustomTool) render(text string, args map[string]interface{}, escape func(string) string) (string, error) {
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	if required, ok := t.Parameters["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok && args[name] == nil {
+				return "", fmt.Errorf("%s needs the %s argument", t.Name, name)
+			}
+		}
+	}
+
+	values := make(map[string]string, len(args))
+	if properties, ok := t.Parameters["properties"].(map[string]interface{}); ok {
+		for name := range properties {
+			values[name] = escape("")
+		}
+	}
+	for key, value := range args {
+		var s string
+		switch value := value.(type) {
+		case string:
+			s = value
+		case nil:
+		default:
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return "", err
+			}
+			s = string(encoded)
+		}
+		values[key] = escape(s)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, values); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", t.Name, err)
+	}
+	return b.String(), nil
+}
+
+// urlEscape percent-encodes a value for use in a URL path segment or query
+// value. Spaces become %20, which unlike the + of url.QueryEscape is also a
+// space in a path.
+func urlEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// jsonEscape escapes a value for use inside a JSON string
+func jsonEscape(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded[1 : len(encoded)-1])
+}
+
+// shellQuote quotes a value for bash
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DiffHunk is a single hunk of a unified diff
+type DiffHunk struct {
+	File       string   // path of the file in the new tree (old path for deletions)
+	FileHeader string   // the "diff --git", index and ---/+++ lines of the file
+	Header     string   // the "@@ -a,b +c,d @@" line
+	OldStart   int      // first line of the hunk in the old file
+	NewStart   int      // first line of the hunk in the new file
+	Lines      []string // hunk body lines, each prefixed with ' ', '+', '-' or '\'
+}
+
+// ParseDiff splits the output of git diff into hunks
+func ParseDiff(diff string) []DiffHunk {
+	var hunks []DiffHunk
+	var header []string
+	var file, oldFile string
+	var current *DiffHunk
+
+	flush := func() {
+		if current != nil {
+			hunks = append(hunks, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			header = []string{line}
+			file, oldFile = "", ""
+		case current == nil && strings.HasPrefix(line, "--- "):
+			header = append(header, line)
+			oldFile = strings.TrimPrefix(strings.TrimPrefix(line, "--- "), "a/")
+		case current == nil && strings.HasPrefix(line, "+++ "):
+			header = append(header, line)
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = oldFile
+			}
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			oldStart, newStart := parseHunkHeader(line)
+			current = &DiffHunk{
+				File:       file,
+				FileHeader: strings.Join(header, "\n"),
+				Header:     line,
+				OldStart:   oldStart,
+				NewStart:   newStart,
+			}
+		case current != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "+") ||
+			strings.HasPrefix(line, "-") || strings.HasPrefix(line, "\\")):
+			current.Lines = append(current.Lines, line)
+		case current == nil && header != nil:
+			header = append(header, line)
+		}
+	}
+	flush()
+
+	return hunks
+}
+
+// diffFiles lists the files touched by a diff, including binary files that
+// have no hunks
+func diffFiles(diff string) []string {
+	var files []string
+	for _, line := range strings.Split(diff, "\n") {
+		if !strings.HasPrefix(line, "diff --git ") {
+			continue
+		}
+		if index := strings.LastIndex(line, " b/"); index >= 0 {
+			files = append(files, line[index+3:])
+		}
+	}
+	return files
+}
+
+// parseHunkHeader extracts the old and new start lines from a hunk header
+func parseHunkHeader(header string) (int, int) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return 0, 0
+	}
+	start := func(field string) int {
+		field = strings.TrimLeft(field, "-+")
+		n, _ := strconv.Atoi(strings.SplitN(field, ",", 2)[0])
+		return n
+	}
+	return start(fields[1]), start(fields[2])
+}
+
+// Patch returns the hunk as a standalone patch that git apply accepts
+func (h DiffHunk) Patch() string {
+	return h.FileHeader + "\n" + h.Header + "\n" + strings.Join(h.Lines, "\n") + "\n"
+}
+
+// Annotated renders the hunk with new-file line numbers so that the model can
+// refer to exact lines
+func (h DiffHunk) Annotated() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s\n%s\n", h.File, h.Header)
+	line := h.NewStart
+	for _, l := range h.Lines {
+		switch {
+		case strings.HasPrefix(l, "-"), strings.HasPrefix(l, "\\"):
+			fmt.Fprintf(&b, "      %s\n", l)
+		default:
+			fmt.Fprintf(&b, "%5d %s\n", line, l)
+			line++
+		}
+	}
+	return b.String()
+}
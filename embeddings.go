@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Defaults of the embeddings API
+const (
+	defaultEmbeddingModel = "gemini-embedding-001"
+	embeddingBatchSize    = 100 // the most texts batchEmbedContents takes at once
+)
+
+// Task types telling the embeddings API how a text will be used
+const (
+	embedDocument = "RETRIEVAL_DOCUMENT"
+	embedQuery    = "RETRIEVAL_QUERY"
+)
+
+// genaiBaseURL is the endpoint of the Gemini API
+var genaiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// embeddingModel returns the model used for embeddings, set with GENAI_EMBEDDING_MODEL
+func embeddingModel() string {
+	if model := os.Getenv("GENAI_EMBEDDING_MODEL"); model != "" {
+		return model
+	}
+	return defaultEmbeddingModel
+}
+
+// Embed returns the embeddings of texts, in order. taskType is embedDocument
+// for the texts being indexed and embedQuery for the questions searching them.
+func Embed(ctx context.Context, texts []string, taskType string) ([][]float32, error) {
+	apiKey := os.Getenv("GENAI_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GENAI_API_KEY environment variable is not set")
+	}
+
+	model := "models/" + strings.TrimPrefix(embeddingModel(), "models/")
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatchSize {
+		end := min(start+embeddingBatchSize, len(texts))
+		batch, err := embedBatch(ctx, apiKey, model, texts[start:end], taskType)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
+
+type embedRequest struct {
+	Model    string `json:"model"`
+	TaskType string `json:"taskType"`
+	Content  struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"content"`
+}
+
+// embedBatch sends one batchEmbedContents request
+func embedBatch(ctx context.Context, apiKey, model string, texts []string, taskType string) ([][]float32, error) {
+	var payload struct {
+		Requests []embedRequest `json:"requests"`
+	}
+	for _, text := range texts {
+		request := embedRequest{Model: model, TaskType: taskType}
+		request.Content.Parts = []struct {
+			Text string `json:"text"`
+		}{{Text: text}}
+		payload.Requests = append(payload.Requests, request)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, genaiBaseURL+"/"+model+":batchEmbedContents", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid embeddings request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", apiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute embeddings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the embeddings: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("failed to compute embeddings: %s", apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("failed to compute embeddings: %s", resp.Status)
+	}
+
+	var result struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse the embeddings: %w", err)
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("asked for %d embeddings but got %d", len(texts), len(result.Embeddings))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range result.Embeddings {
+		embeddings[i] = embedding.Values
+	}
+	return embeddings, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors, or 0
+// when their lengths differ
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// encodeVector packs a vector as little-endian float32s for storage
+func encodeVector(v []float32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	return data
+}
+
+// decodeVector unpacks a vector stored with encodeVector
+func decodeVector(data []byte) []float32 {
+	v := make([]float32, len(data)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var explainSystemPrompt = `You help engineers new to a codebase understand why code looks the way it does.
+You are given git history for a commit, a file or a range of lines: commit messages, diffs,
+blame output and line history. Write a clear narrative that explains what changed, in what
+order, and why, citing commit hashes, authors and dates where they matter. Infer intent from
+commit messages and the shape of the diffs, but say so when the reason is not recorded.
+Finish with anything a newcomer should be careful about when changing this code.`
+
+// ExplainCmd represents the explain command
+var ExplainCmd = &cobra.Command{
+	Use:   "explain <commit|file[:lines]>",
+	Short: "Explain a commit, file or line range from its git history",
+	Long: `Gathers git show, git blame and git log -L output for the target and asks the AI to
+explain what changed and why. Lines can be a single line or a range:
+
+  ai explain 3f2a1c9
+  ai explain git.go
+  ai explain git.go:100-130`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		processingMsg := color.New(color.FgYellow).PrintFunc()
+		processingMsg("Reading git history...\n")
+
+		history, err := CollectExplainContext(path, args[0])
+		if err != nil {
+			return err
+		}
+
+		schema := sapiens.Schema{
+			Type: "object",
+			Properties: map[string]sapiens.Schema{
+				"explanation": {
+					Type:        "string",
+					Description: "The narrative explanation of the history",
+				},
+			},
+			Required: []string{"explanation"},
+		}
+
+		query := fmt.Sprintf("Explain the history of %s.\n\n%s", args[0], limitDiffSize(history, 30000))
+		fields, err := AskStructured(query, explainSystemPrompt, schema)
+		if err != nil {
+			return err
+		}
+
+		color.New(color.FgGreen, color.Bold).Printf("\nExplanation of %s:\n\n", args[0])
+		fmt.Println(strings.TrimSpace(stringField(fields, "explanation")))
+		return nil
+	},
+}
+
+func init() {
+	ExplainCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// CollectExplainContext gathers the git history the explanation is based on
+func CollectExplainContext(path, target string) (string, error) {
+	file, start, end := parseFileTarget(target)
+
+	// A target that is not a file in the work tree is treated as a revision
+	if _, err := os.Stat(fileInRepo(path, file)); err != nil {
+		if _, revErr := GitOutput(path, "rev-parse", "--verify", "--quiet", target+"^{commit}"); revErr != nil {
+			return "", fmt.Errorf("%s is neither a file nor a commit", target)
+		}
+		return GitOutput(path, "show", "--stat", "--patch", "--format=fuller", target)
+	}
+
+	var sections []string
+	add := func(title string, args ...string) error {
+		output, err := GitOutput(path, args...)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, fmt.Sprintf("=== %s ===\n%s", title, output))
+		return nil
+	}
+
+	if start > 0 {
+		lines := fmt.Sprintf("%d,%d", start, end)
+		if err := add("git blame", "blame", "--date=short", "-L", lines, "--", file); err != nil {
+			return "", err
+		}
+		if err := add("git log -L", "log", "-n", "10", "--format=commit %h%nAuthor: %an%nDate: %as%n%n%s%n%b", "-L", lines+":"+file); err != nil {
+			return "", err
+		}
+	} else {
+		if err := add("git log", "log", "--follow", "-n", "20", "--stat", "--format=commit %h%nAuthor: %an%nDate: %as%n%n%s%n%b", "--", file); err != nil {
+			return "", err
+		}
+		if err := add("git blame", "blame", "--date=short", "--", file); err != nil {
+			return "", err
+		}
+	}
+
+	// Include the most recent change to the file in full for context
+	if err := add("git show (latest change)", "log", "-n", "1", "--patch", "--format=fuller", "--", file); err != nil {
+		return "", err
+	}
+
+	return strings.Join(sections, "\n\n"), nil
+}
+
+// parseFileTarget splits "file:10-20" or "file:10" into the file and line range.
+// start is 0 when no range is given.
+func parseFileTarget(target string) (string, int, int) {
+	index := strings.LastIndex(target, ":")
+	if index < 0 {
+		return target, 0, 0
+	}
+
+	lines := target[index+1:]
+	startStr, endStr, isRange := strings.Cut(strings.ReplaceAll(lines, ",", "-"), "-")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 1 {
+		return target, 0, 0
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(endStr); err != nil || end < start {
+			return target, 0, 0
+		}
+	}
+	return target[:index], start, end
+}
+
+// fileInRepo resolves file relative to the repository path
+func fileInRepo(path, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(path, file)
+}
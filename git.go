@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -45,6 +47,11 @@ var GitCommitCmd = &cobra.Command{
 		}
 
 		systemPrompt, _ := cmd.Flags().GetString("prompt")
+
+		if split, _ := cmd.Flags().GetBool("split"); split {
+			return runCommitSplit(path, systemPrompt)
+		}
+
 		commitMessage, changedFiles := GenerateCommitMessage(path, systemPrompt)
 
 		if len(changedFiles) == 0 {
@@ -77,6 +84,7 @@ var GitCommitCmd = &cobra.Command{
 
 func init() {
 	GitCommitCmd.Flags().StringP("prompt", "p", "", "Custom system prompt for generating the commit message")
+	GitCommitCmd.Flags().BoolP("split", "s", false, "Split the changes into several logical commits")
 }
 
 // IsGitRepo checks if the given path is a git repository
@@ -99,37 +107,86 @@ func HasUncommittedChanges(path string) bool {
 
 // GenerateCommitMessage generates a commit message using git diff and the Gemini API
 func GenerateCommitMessage(path, systemPrompt string) (string, []string) {
-	if systemPrompt == "" {
-		systemPrompt = "Generate a commit message in present tense and less than 50 words for the following changes:"
-	}
-
-	cmd := exec.Command("git", "-C", path, "diff", "--name-only")
-	output, err := cmd.Output()
+	changedFiles, diffOutput, err := GetDiff(path)
 	if err != nil {
 		return color.RedString("Error getting git diff: %v", err), nil
 	}
 
-	changedFiles := strings.Split(strings.TrimSpace(string(output)), "\n")
-	if len(changedFiles) == 1 && changedFiles[0] == "" {
+	if len(changedFiles) == 0 {
 		return color.RedString("No changed files found"), nil
 	}
 
-	// Get changed files content for better context
-	cmd = exec.Command("git", "-C", path, "diff")
-	diffOutput, err := cmd.Output()
+	message, err := CommitMessageForDiff(context.Background(), changedFiles, diffOutput, systemPrompt)
 	if err != nil {
-		return color.RedString("Error getting git diff content: %v", err), nil
+		log.Fatal(err)
+	}
+	return message, changedFiles
+}
+
+// CommitMessageForDiff asks the AI for a commit message describing a diff
+func CommitMessageForDiff(ctx context.Context, changedFiles []string, diff, systemPrompt string) (string, error) {
+	if systemPrompt == "" {
+		systemPrompt = "Generate a commit message in present tense and less than 50 words for the following changes:"
 	}
 
 	// Prepare the prompt with file names and diff content
 	query := fmt.Sprintf("%s\n\nChanged files:\n%s\n\nDiff:\n%s",
 		systemPrompt,
 		strings.Join(changedFiles, "\n"),
-		limitDiffSize(string(diffOutput), 4000)) // Limit diff size to avoid token limits
+		limitDiffSize(diff, 4000)) // Limit diff size to avoid token limits
 
-	// Use AskQuery from gemini.go
-	result := AskQuery(query, nil)
-	return result.Response, changedFiles
+	result, err := AskQueryContext(ctx, query, nil)
+	if err != nil {
+		return "", err
+	}
+	return result.Response, nil
+}
+
+// GitOutput runs a git subcommand in the repository at path and returns its stdout
+func GitOutput(path string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", path}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return string(output), nil
+}
+
+// GetDiff returns the changed file names and the diff content for the given
+// git diff arguments (e.g. "--cached" or "main...HEAD"). With no arguments it
+// describes the unstaged changes of the working tree.
+func GetDiff(path string, args ...string) ([]string, string, error) {
+	names, err := GitOutput(path, append([]string{"diff", "--name-only"}, args...)...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var changedFiles []string
+	for _, name := range strings.Split(strings.TrimSpace(names), "\n") {
+		if name != "" {
+			changedFiles = append(changedFiles, name)
+		}
+	}
+
+	diff, err := GitOutput(path, append([]string{"diff"}, args...)...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return changedFiles, diff, nil
+}
+
+// ApplyToIndex stages a patch with git apply --cached without touching the work tree
+func ApplyToIndex(path, patch string) error {
+	cmd := exec.Command("git", "-C", path, "apply", "--cached", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git apply --cached: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
 }
 
 // limitDiffSize limits the diff output size to avoid token limits
@@ -154,3 +211,26 @@ func CommitChanges(path, commitMessage string) error {
 
 	return nil
 }
+
+// DefaultBaseBranch guesses the branch the current branch was created from,
+// preferring the remote's default branch and falling back to main or master
+func DefaultBaseBranch(path string) string {
+	if ref, err := GitOutput(path, "symbolic-ref", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		return strings.TrimSpace(ref)
+	}
+	for _, branch := range []string{"main", "master"} {
+		if _, err := GitOutput(path, "rev-parse", "--verify", "--quiet", branch); err == nil {
+			return branch
+		}
+	}
+	return "main"
+}
+
+// RepoRoot returns the top-level directory of the repository containing path
+func RepoRoot(path string) (string, error) {
+	root, err := GitOutput(path, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(root), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonNode is a JSON value that remembers the order of object keys, so that
+// translated resource files keep the layout of the original
+type jsonNode struct {
+	Key      string      // member name when the parent is an object
+	Kind     byte        // '{', '[' or 0 for scalars
+	Value    interface{} // scalar value: string, json.Number, bool or nil
+	Children []*jsonNode
+}
+
+// parseOrderedJSON parses a JSON document keeping the key order
+func parseOrderedJSON(data []byte) (*jsonNode, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	node, err := decodeJSONNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON document")
+	}
+	return node, nil
+}
+
+func decodeJSONNode(decoder *json.Decoder) (*jsonNode, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return &jsonNode{Value: token}, nil
+	}
+
+	node := &jsonNode{Kind: byte(delim)}
+	for decoder.More() {
+		key := ""
+		if delim == '{' {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key = keyToken.(string)
+		}
+		child, err := decodeJSONNode(decoder)
+		if err != nil {
+			return nil, err
+		}
+		child.Key = key
+		node.Children = append(node.Children, child)
+	}
+
+	// Consume the closing delimiter
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// stringLeaves returns the string values of the tree keyed by their path, e.g.
+// "home.title" or "items[2]", in document order
+func (n *jsonNode) stringLeaves(path string, keys *[]string, leaves map[string]*jsonNode) {
+	switch n.Kind {
+	case '{':
+		for _, child := range n.Children {
+			childPath := child.Key
+			if path != "" {
+				childPath = path + "." + child.Key
+			}
+			child.stringLeaves(childPath, keys, leaves)
+		}
+	case '[':
+		for i, child := range n.Children {
+			child.stringLeaves(fmt.Sprintf("%s[%d]", path, i), keys, leaves)
+		}
+	default:
+		if _, ok := n.Value.(string); ok {
+			*keys = append(*keys, path)
+			leaves[path] = n
+		}
+	}
+}
+
+// marshalOrderedJSON writes the tree as JSON indented with two spaces
+func marshalOrderedJSON(n *jsonNode) []byte {
+	var b bytes.Buffer
+	n.write(&b, "")
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+func (n *jsonNode) write(b *bytes.Buffer, indent string) {
+	if n.Kind == 0 {
+		b.Write(encodeJSONScalar(n.Value))
+		return
+	}
+
+	open, close := "{", "}"
+	if n.Kind == '[' {
+		open, close = "[", "]"
+	}
+	if len(n.Children) == 0 {
+		b.WriteString(open + close)
+		return
+	}
+
+	b.WriteString(open + "\n")
+	for i, child := range n.Children {
+		b.WriteString(indent + "  ")
+		if n.Kind == '{' {
+			b.Write(encodeJSONScalar(child.Key))
+			b.WriteString(": ")
+		}
+		child.write(b, indent+"  ")
+		if i < len(n.Children)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(indent + close)
+}
+
+// encodeJSONScalar encodes a scalar without escaping HTML characters, which are
+// common in translated strings
+func encodeJSONScalar(value interface{}) []byte {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return bytes.TrimRight(b.Bytes(), "\n")
+}
+
+// poEntry is a message of a gettext PO file
+type poEntry struct {
+	Lines       []string // the original lines of the entry
+	MsgID       string
+	MsgIDPlural string
+	MsgStr      []string // msgstr, or msgstr[0..n] for plural messages
+	strStart    int      // index in Lines of the first msgstr line, -1 if none
+	strEnd      int      // index in Lines after the last msgstr line
+}
+
+// parsePO splits a PO file into entries separated by blank lines
+func parsePO(content string) []*poEntry {
+	var entries []*poEntry
+	var current *poEntry
+	var field *string
+
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			current, field = nil, nil
+			entries = append(entries, &poEntry{Lines: []string{line}, strStart: -1})
+			continue
+		}
+		if current == nil {
+			current = &poEntry{strStart: -1}
+			entries = append(entries, current)
+		}
+		current.Lines = append(current.Lines, line)
+		index := len(current.Lines) - 1
+
+		keyword, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch {
+		case strings.HasPrefix(line, "#"):
+			field = nil
+		case strings.HasPrefix(line, "\""):
+			if field != nil {
+				*field += unquotePO(line)
+			}
+			if current.strStart >= 0 && index == current.strEnd {
+				current.strEnd = index + 1
+			}
+		case keyword == "msgid":
+			current.MsgID = unquotePO(rest)
+			field = &current.MsgID
+		case keyword == "msgid_plural":
+			current.MsgIDPlural = unquotePO(rest)
+			field = &current.MsgIDPlural
+		case keyword == "msgstr" || strings.HasPrefix(keyword, "msgstr["):
+			if current.strStart < 0 {
+				current.strStart = index
+			}
+			current.strEnd = index + 1
+			current.MsgStr = append(current.MsgStr, unquotePO(rest))
+			field = &current.MsgStr[len(current.MsgStr)-1]
+		default:
+			field = nil
+		}
+	}
+	return entries
+}
+
+// untranslated reports whether the entry is a message without a translation
+func (e *poEntry) untranslated() bool {
+	if e.strStart < 0 || e.MsgID == "" {
+		return false
+	}
+	for _, str := range e.MsgStr {
+		if str != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// setTranslation replaces the msgstr lines of the entry. Plural messages get
+// the singular translation in msgstr[0] and the plural one in the others.
+func (e *poEntry) setTranslation(singular, plural string) {
+	var lines []string
+	if e.MsgIDPlural == "" {
+		lines = []string{"msgstr " + strconv.Quote(singular)}
+	} else {
+		for i := range e.MsgStr {
+			text := plural
+			if i == 0 {
+				text = singular
+			}
+			lines = append(lines, fmt.Sprintf("msgstr[%d] %s", i, strconv.Quote(text)))
+		}
+	}
+
+	updated := append([]string{}, e.Lines[:e.strStart]...)
+	updated = append(updated, lines...)
+	e.Lines = append(updated, e.Lines[e.strEnd:]...)
+}
+
+// formatPO joins the entries back into a PO file
+func formatPO(entries []*poEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// unquotePO decodes a quoted PO string, returning it unchanged if it is malformed
+func unquotePO(quoted string) string {
+	quoted = strings.TrimSpace(quoted)
+	value, err := strconv.Unquote(quoted)
+	if err != nil {
+		return strings.Trim(quoted, "\"")
+	}
+	return value
+}
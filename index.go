@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Limits of the index
+const (
+	defaultChunkSize = 1500    // characters per chunk
+	maxChunkSize     = 8000    // about the most the embedding model reads of a text
+	maxIndexFileSize = 1 << 20 // larger files are skipped
+	defaultTopK      = 5       // chunks given to ai ask --context
+)
+
+// skippedIndexDirs are not indexed outside of git repositories
+var skippedIndexDirs = map[string]bool{"node_modules": true, "vendor": true, "__pycache__": true}
+
+// IndexCmd indexes a directory for ai ask --context
+var IndexCmd = &cobra.Command{
+	Use:   "index <dir>",
+	Short: "Index the text files of a directory for ai ask --context",
+	Long: `Index the text files of a directory for ai ask --context.
+
+The files are split into chunks whose embeddings are stored in ~/.gema/gema.db.
+In a git repository the tracked and untracked files not ignored by git are
+indexed, elsewhere hidden files and dependency directories are skipped. Running
+it again only reindexes the files that changed and forgets the removed ones.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunkSize, _ := cmd.Flags().GetInt("chunk-size")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		if chunkSize <= 0 || chunkSize > maxChunkSize {
+			return fmt.Errorf("--chunk-size must be between 1 and %d", maxChunkSize)
+		}
+
+		storage, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		stats, err := indexDirectory(context.Background(), storage, args[0], chunkSize, exclude)
+		if err != nil {
+			return err
+		}
+		color.New(color.FgGreen, color.Bold).Printf("✓ Indexed %d files (%d chunks), %d unchanged, %d removed\n",
+			stats.indexed, stats.chunks, stats.unchanged, stats.removed)
+		return nil
+	},
+}
+
+func init() {
+	IndexCmd.Flags().Int("chunk-size", defaultChunkSize, "Characters per chunk, changing it reindexes every file")
+	IndexCmd.Flags().StringSlice("exclude", nil, "Glob of files to skip, matched against the path and the file name (repeatable)")
+}
+
+// indexStats counts what indexDirectory did
+type indexStats struct {
+	indexed, chunks, unchanged, removed int
+}
+
+// indexDirectory brings the index of dir up to date. Files whose mtime, or
+// else content, is unchanged since they were indexed are skipped.
+func indexDirectory(ctx context.Context, storage *Storage, dir string, chunkSize int, exclude []string) (indexStats, error) {
+	var stats indexStats
+
+	root, err := indexRoot(dir)
+	if err != nil {
+		return stats, err
+	}
+	files, err := listIndexFiles(root)
+	if err != nil {
+		return stats, err
+	}
+	indexed, err := storage.IndexedFiles(root)
+	if err != nil {
+		return stats, err
+	}
+
+	model := embeddingModel()
+	seen := map[string]bool{}
+	for _, path := range files {
+		if matchesAny(exclude, path) || matchesAny(exclude, filepath.Base(path)) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil || !info.Mode().IsRegular() || info.Size() == 0 || info.Size() > maxIndexFileSize {
+			continue
+		}
+
+		previous, known := indexed[path]
+		upToDate := known && previous.Model == model && previous.ChunkSize == chunkSize
+		if upToDate && previous.Mtime == info.ModTime().UnixNano() {
+			seen[path] = true
+			stats.unchanged++
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil || !isText(data) {
+			continue
+		}
+		seen[path] = true
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if upToDate && previous.Hash == hash {
+			if err := storage.TouchIndexedFile(previous.ID, info.ModTime().UnixNano()); err != nil {
+				return stats, err
+			}
+			stats.unchanged++
+			continue
+		}
+
+		chunks := chunkText(string(data), chunkSize)
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = fmt.Sprintf("File: %s\n\n%s", path, chunk.Content)
+		}
+		vectors, err := Embed(ctx, texts, embedDocument)
+		if err != nil {
+			return stats, fmt.Errorf("failed to index %s: %w", path, err)
+		}
+		for i := range chunks {
+			chunks[i].Embedding = vectors[i]
+		}
+
+		file := IndexedFile{Path: path, Mtime: info.ModTime().UnixNano(), Hash: hash, Model: model, ChunkSize: chunkSize}
+		if err := storage.ReplaceIndexedFile(root, file, chunks); err != nil {
+			return stats, err
+		}
+		color.New(color.FgHiBlack).Printf("  %s (%d chunks)\n", path, len(chunks))
+		stats.indexed++
+		stats.chunks += len(chunks)
+	}
+
+	for path, file := range indexed {
+		if !seen[path] {
+			if err := storage.RemoveIndexedFile(file.ID); err != nil {
+				return stats, err
+			}
+			stats.removed++
+		}
+	}
+	return stats, nil
+}
+
+// indexRoot returns the absolute path the index of dir is stored under
+func indexRoot(dir string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return root, nil
+}
+
+// listIndexFiles returns the slash-separated paths, relative to root, of the
+// files that may be indexed
+func listIndexFiles(root string) ([]string, error) {
+	if IsGitRepo(root) {
+		output, err := GitOutput(root, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, path := range strings.Split(output, "\x00") {
+			if path != "" {
+				files = append(files, path)
+			}
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if path != root && (strings.HasPrefix(name, ".") || skippedIndexDirs[name]) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", root, err)
+	}
+	return files, nil
+}
+
+// isText reports whether a file looks like text rather than binary data
+func isText(data []byte) bool {
+	return !bytes.Contains(data, []byte{0}) && utf8.Valid(data)
+}
+
+// chunkText splits a text into chunks of whole lines of about size characters.
+// Lines longer than a chunk are split across chunks of their own.
+func chunkText(text string, size int) []IndexChunk {
+	var chunks []IndexChunk
+	var current strings.Builder
+	start := 1
+
+	flush := func(end int) {
+		if strings.TrimSpace(current.String()) != "" {
+			chunks = append(chunks, IndexChunk{StartLine: start, EndLine: end, Content: current.String()})
+		}
+		current.Reset()
+		start = end + 1
+	}
+
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if current.Len() > 0 && current.Len()+len(line)+1 > size {
+			flush(i)
+		}
+		for len(line) > size {
+			// Cut at a character boundary
+			cut := size
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = size
+			}
+			if strings.TrimSpace(line[:cut]) != "" {
+				chunks = append(chunks, IndexChunk{StartLine: i + 1, EndLine: i + 1, Content: line[:cut]})
+			}
+			line = line[cut:]
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush(len(lines))
+	return chunks
+}
+
+// IndexedFile is a file of an indexed directory
+type IndexedFile struct {
+	ID        int64
+	Path      string // relative to the indexed directory
+	Mtime     int64  // in nanoseconds
+	Hash      string // SHA-256 of the content
+	Model     string // embedding model
+	ChunkSize int
+}
+
+// IndexChunk is a part of an indexed file and its embedding
+type IndexChunk struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Content   string
+	Embedding []float32
+	Score     float64 // similarity to the query, set by SearchIndex
+}
+
+// IndexedFiles returns the files indexed under root by path
+func (s *Storage) IndexedFiles(root string) (map[string]IndexedFile, error) {
+	rows, err := s.db.Query("SELECT id, path, mtime, hash, model, chunk_size FROM index_files WHERE root = ?", root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index: %w", err)
+	}
+	defer rows.Close()
+
+	files := map[string]IndexedFile{}
+	for rows.Next() {
+		var f IndexedFile
+		if err := rows.Scan(&f.ID, &f.Path, &f.Mtime, &f.Hash, &f.Model, &f.ChunkSize); err != nil {
+			return nil, fmt.Errorf("failed to read the index: %w", err)
+		}
+		files[f.Path] = f
+	}
+	return files, rows.Err()
+}
+
+// ReplaceIndexedFile stores a file and its chunks, replacing the previous ones
+func (s *Storage) ReplaceIndexedFile(root string, file IndexedFile, chunks []IndexChunk) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update the index: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow("SELECT id FROM index_files WHERE root = ? AND path = ?", root, file.Path).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		result, err := tx.Exec("INSERT INTO index_files (root, path, mtime, hash, model, chunk_size) VALUES (?, ?, ?, ?, ?, ?)",
+			root, file.Path, file.Mtime, file.Hash, file.Model, file.ChunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to update the index: %w", err)
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to update the index: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to update the index: %w", err)
+	default:
+		if _, err := tx.Exec("UPDATE index_files SET mtime = ?, hash = ?, model = ?, chunk_size = ? WHERE id = ?",
+			file.Mtime, file.Hash, file.Model, file.ChunkSize, id); err != nil {
+			return fmt.Errorf("failed to update the index: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM index_chunks WHERE file_id = ?", id); err != nil {
+			return fmt.Errorf("failed to update the index: %w", err)
+		}
+	}
+
+	for _, chunk := range chunks {
+		if _, err := tx.Exec("INSERT INTO index_chunks (file_id, start_line, end_line, content, embedding) VALUES (?, ?, ?, ?, ?)",
+			id, chunk.StartLine, chunk.EndLine, chunk.Content, encodeVector(chunk.Embedding)); err != nil {
+			return fmt.Errorf("failed to update the index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// TouchIndexedFile records the new mtime of a file whose content did not change
+func (s *Storage) TouchIndexedFile(id, mtime int64) error {
+	if _, err := s.db.Exec("UPDATE index_files SET mtime = ? WHERE id = ?", mtime, id); err != nil {
+		return fmt.Errorf("failed to update the index: %w", err)
+	}
+	return nil
+}
+
+// RemoveIndexedFile forgets a file and its chunks
+func (s *Storage) RemoveIndexedFile(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update the index: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM index_chunks WHERE file_id = ?", id); err != nil {
+		return fmt.Errorf("failed to update the index: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM index_files WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to update the index: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SearchIndex returns the k chunks indexed under root with the given embedding
+// model that are the most similar to the query vector
+func (s *Storage) SearchIndex(root, model string, query []float32, k int) ([]IndexChunk, error) {
+	rows, err := s.db.Query(`
+		SELECT f.path, c.start_line, c.end_line, c.content, c.embedding
+		FROM index_chunks c JOIN index_files f ON f.id = c.file_id
+		WHERE f.root = ? AND f.model = ?`, root, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search the index: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []IndexChunk
+	for rows.Next() {
+		var c IndexChunk
+		var embedding []byte
+		if err := rows.Scan(&c.Path, &c.StartLine, &c.EndLine, &c.Content, &embedding); err != nil {
+			return nil, fmt.Errorf("failed to search the index: %w", err)
+		}
+		c.Score = cosineSimilarity(query, decodeVector(embedding))
+		chunks = append(chunks, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search the index: %w", err)
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].Score > chunks[j].Score })
+	if len(chunks) > k {
+		chunks = chunks[:k]
+	}
+	return chunks, nil
+}
+
+// retrieveContext returns the k chunks of the index of dir most relevant to a question
+func retrieveContext(ctx context.Context, dir, question string, k int) ([]IndexChunk, error) {
+	root, err := indexRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := NewStorage()
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	vectors, err := Embed(ctx, []string{question}, embedQuery)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := storage.SearchIndex(root, embeddingModel(), vectors[0], k)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%s is not indexed with %s, run ai index %s first", dir, embeddingModel(), dir)
+	}
+	return chunks, nil
+}
+
+// chunkCitation returns how a chunk of the index of dir is cited, as path:start-end
+func chunkCitation(dir string, chunk IndexChunk) string {
+	return fmt.Sprintf("%s:%d-%d", filepath.Join(dir, filepath.FromSlash(chunk.Path)), chunk.StartLine, chunk.EndLine)
+}
+
+// contextQuery adds the excerpts retrieved from the index of dir to a question
+func contextQuery(dir, question string, chunks []IndexChunk) string {
+	var b strings.Builder
+	b.WriteString("Answer the question using the excerpts below from the files in " + dir + " where they are relevant. ")
+	b.WriteString("Cite the excerpts you use by their file and lines, as in (path:12-40).\n\n")
+	for i, chunk := range chunks {
+		fmt.Fprintf(&b, "[%d] %s\n```\n%s```\n\n", i+1, chunkCitation(dir, chunk), chunk.Content)
+	}
+	b.WriteString("Question: " + question)
+	return b.String()
+}
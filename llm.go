@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/4nkitd/sapiens"
 )
@@ -14,26 +16,71 @@ type AiResponse struct {
 	Command  string `json:"command"`
 }
 
-func AskQuery(query string, imageBytes [][]byte) AiResponse {
+// newAgent initializes the configured LLM and returns a Sapiens agent for it
+func newAgent() (*sapiens.Agent, error) {
+	return newAgentForModel("")
+}
+
+// newAgentForModel returns an agent for the given model, or for the configured
+// one when model is empty
+func newAgentForModel(model string) (*sapiens.Agent, error) {
 	apiKey := os.Getenv("GENAI_API_KEY")
 	if apiKey == "" {
-		log.Fatal("GENAI_API_KEY environment variable is not set")
+		return nil, fmt.Errorf("GENAI_API_KEY environment variable is not set")
 	}
 
-	defaultModel := os.Getenv("GENAI_DEFAULT_MODEL")
+	defaultModel := model
 	if defaultModel == "" {
-		log.Fatal("GENAI_DEFAULT_MODEL environment variable is not set")
+		defaultModel = os.Getenv("GENAI_DEFAULT_MODEL")
+	}
+	if defaultModel == "" {
+		return nil, fmt.Errorf("GENAI_DEFAULT_MODEL environment variable is not set")
 	}
 
 	// Initialize the Sapiens LLM client
 	llm := sapiens.NewGoogleGenAI(apiKey, defaultModel)
 
 	if err := llm.Initialize(); err != nil {
-		log.Fatalf("Failed to initialize LLM: %v", err)
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
 	}
 
 	// Create a Sapiens agent
-	agent := sapiens.NewAgent("GemaCLI", llm, apiKey, defaultModel, "google")
+	return sapiens.NewAgent("GemaCLI", llm, apiKey, defaultModel, genaiProvider), nil
+}
+
+// genaiProvider is the provider of the models the agents use
+const genaiProvider = "google"
+
+// modelName returns the model the calls of a context use
+func modelName(ctx context.Context) string {
+	if model := contextModel(ctx); model != "" {
+		return model
+	}
+	return os.Getenv("GENAI_DEFAULT_MODEL")
+}
+
+type modelKey struct{}
+
+// withModel returns a context whose queries use model instead of GENAI_DEFAULT_MODEL
+func withModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// contextModel returns the model set with withModel, or "" for the configured one
+func contextModel(ctx context.Context) string {
+	model, _ := ctx.Value(modelKey{}).(string)
+	return model
+}
+
+// newAssistantAgent returns an agent with the assistant's system prompt and
+// tools, including the custom tools and those of the configured MCP servers.
+// Extra instructions are appended to the system prompt. It also returns the
+// names of the tools, and their calls are noted in the tool call record of ctx.
+func newAssistantAgent(ctx context.Context, instructions string) (*sapiens.Agent, []string, error) {
+	agent, err := newAgentForModel(contextModel(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Define system info tool
 	sysInfoTool := sapiens.Tool{
@@ -45,11 +92,47 @@ func AskQuery(query string, imageBytes [][]byte) AiResponse {
 	agent.AddTools(sysInfoTool)
 	// Register tool implementation
 	agent.RegisterToolImplementation("get_system_info", func(params map[string]interface{}) (interface{}, error) {
+		noteToolCall(ctx)
 		return GetSystemInfo(params)
 	})
+	tools := []string{"get_system_info"}
+	tools = append(tools, registerCustomTools(ctx, agent)...)
+	tools = append(tools, registerMCPTools(ctx, agent)...)
 
 	// Add system prompt (without system info directly embedded)
-	agent.AddSystemPrompt(SystemInstruction, "1.0")
+	systemPrompt := SystemInstruction
+	if instructions != "" {
+		systemPrompt += "\n\n" + instructions
+	}
+	agent.AddSystemPrompt(systemPrompt, "1.0")
+
+	return agent, tools, nil
+}
+
+// AskQuery asks the assistant a question and exits the program on failure.
+// Long-running callers such as the web server use AskQueryContext instead.
+// Calls to MCP and custom tools are confirmed on the terminal, if there is one.
+func AskQuery(query string, imageBytes [][]byte) AiResponse {
+	ctx := context.Background()
+	if stdinIsTerminal() {
+		ctx = withToolConfirmer(ctx, confirmOnTerminal)
+	}
+
+	result, err := AskQueryContext(ctx, query, imageBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return result
+}
+
+// AskQueryContext asks the assistant a question and returns its response and
+// suggested command
+func AskQueryContext(ctx context.Context, query string, imageBytes [][]byte) (AiResponse, error) {
+	ctx = withToolCallRecord(ctx)
+	agent, tools, err := newAssistantAgent(ctx, "")
+	if err != nil {
+		return AiResponse{}, err
+	}
 
 	// Define schema for structured output
 	schema := sapiens.Schema{
@@ -70,9 +153,6 @@ func AskQuery(query string, imageBytes [][]byte) AiResponse {
 	// Set the schema on your agent
 	agent.SetStructuredResponseSchema(schema)
 
-	// Create context and run the query
-	ctx := context.Background()
-
 	// Handle image attachments if present
 	if len(imageBytes) > 0 {
 		for _, imgBytes := range imageBytes {
@@ -83,9 +163,16 @@ func AskQuery(query string, imageBytes [][]byte) AiResponse {
 	}
 
 	// Run the agent with the query
-	response, err := agent.Run(ctx, query)
+	encodedSchema, _ := json.Marshal(schema)
+	response, entry, err := runAgent(ctx, agent, modelCall{
+		System:      SystemInstruction,
+		Format:      string(encodedSchema),
+		Input:       query,
+		Attachments: imageBytes,
+		Tools:       tools,
+	})
 	if err != nil {
-		log.Fatalf("Error from agent: %v", err)
+		return AiResponse{}, err
 	}
 
 	// fmt.Println("Response:", response.Content)
@@ -130,13 +217,185 @@ func AskQuery(query string, imageBytes [][]byte) AiResponse {
 
 	// Validate that we have at least a response
 	if result.Response == "" {
-		log.Fatal("Response field is missing or not a string")
+		return AiResponse{}, fmt.Errorf("response field is missing or not a string")
 	}
 
-	errDb := StoreCommandHistory(query, result.Response)
+	entry.Response = result.Response
+	errDb := StoreCommandHistory(entry)
 	if errDb != nil {
-		log.Fatal(errDb)
+		return AiResponse{}, errDb
 	}
 
-	return result
+	return result, nil
+}
+
+// ImageAttachment is an image sent along with a question
+type ImageAttachment struct {
+	Data     []byte
+	MimeType string
+}
+
+// ChatContext asks the assistant a question and returns its answer as free
+// text, without the response and command fields of AskQuery. Instructions are
+// appended to the assistant's system prompt.
+func ChatContext(ctx context.Context, query, instructions string, images []ImageAttachment) (string, error) {
+	ctx = withToolCallRecord(ctx)
+	agent, tools, err := newAssistantAgent(ctx, instructions)
+	if err != nil {
+		return "", err
+	}
+
+	call := modelCall{System: SystemInstruction + "\n\n" + instructions, Format: "text", Input: query, Tools: tools}
+	for _, image := range images {
+		agent.AddImageContent(image.Data, image.MimeType)
+		call.Attachments = append(call.Attachments, []byte(image.MimeType), image.Data)
+	}
+
+	response, entry, err := runAgent(ctx, agent, call)
+	if err != nil {
+		return "", err
+	}
+
+	content := strings.TrimSpace(response.Content)
+	if content == "" {
+		return "", fmt.Errorf("model returned an empty response")
+	}
+
+	entry.Response = content
+	if err := StoreCommandHistory(entry); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// AskStructured runs a query with its own system prompt and response schema and
+// returns the fields of the structured response. Unlike AskQuery it reports
+// failures to the caller instead of exiting.
+func AskStructured(query, systemPrompt string, schema sapiens.Schema) (map[string]interface{}, error) {
+	return AskStructuredContext(context.Background(), query, systemPrompt, schema)
+}
+
+// AskStructuredContext is AskStructured with a context that can cancel the query
+func AskStructuredContext(ctx context.Context, query, systemPrompt string, schema sapiens.Schema) (map[string]interface{}, error) {
+	agent, err := newAgentForModel(contextModel(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	agent.AddSystemPrompt(systemPrompt, "1.0")
+	agent.SetStructuredResponseSchema(schema)
+
+	encodedSchema, _ := json.Marshal(schema)
+	response, entry, err := runAgent(ctx, agent, modelCall{System: systemPrompt, Format: string(encodedSchema), Input: query})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := structuredFields(response.Structured, response.Content)
+	if fields == nil {
+		return nil, fmt.Errorf("model did not return a structured response")
+	}
+
+	stored, _ := json.Marshal(fields)
+	entry.Response = string(stored)
+	if err := StoreCommandHistory(entry); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// AskJSON runs a query whose system prompt asks for a JSON document and decodes
+// the reply into out. It is used for replies the prompt describes, such as bare
+// JSON arrays, instead of a response schema.
+func AskJSON(query, systemPrompt string, out interface{}) error {
+	agent, err := newAgent()
+	if err != nil {
+		return err
+	}
+
+	agent.AddSystemPrompt(systemPrompt, "1.0")
+
+	response, entry, err := runAgent(context.Background(), agent, modelCall{System: systemPrompt, Format: "json", Input: query})
+	if err != nil {
+		return err
+	}
+
+	content := stripCodeFence(response.Content)
+	if err := json.Unmarshal([]byte(content), out); err != nil {
+		return fmt.Errorf("model returned invalid JSON: %w", err)
+	}
+
+	entry.Response = content
+	return StoreCommandHistory(entry)
+}
+
+// runAgent runs a query and adds its token usage to the usage meter of the
+// context, if there is one. When the response cache is on, a cached response
+// is returned instead, and the response is only cached when the agent called
+// no tool, since a tool may return something else next time. It also returns
+// the history entry of the call, with its usage and cost, for the caller to
+// complete with the response and store.
+func runAgent(ctx context.Context, agent *sapiens.Agent, call modelCall) (*sapiens.Response, HistoryEntry, error) {
+	model := modelName(ctx)
+	entry := HistoryEntry{Input: call.Input, Command: commandName(ctx), Model: model}
+	key := call.cacheKey(genaiProvider, model)
+	ttl, useCache := responseCacheTTL()
+	if useCache && !cacheSkipped(ctx) {
+		if response, saved, ok := lookupCachedResponse(ctx, key, ttl); ok {
+			entry.Cached = true
+			entry.Usage.SavedTokens = saved.TotalTokens
+			free := 0.0
+			entry.Cost = &free
+			return response, entry, nil
+		}
+	}
+
+	response, err := agent.Run(ctx, call.Input)
+	if err != nil {
+		return nil, entry, fmt.Errorf("error from agent: %w", err)
+	}
+
+	entry.Usage = responseUsage(response)
+	entry.Cost = usageCost(model, entry.Usage)
+	recordUsage(ctx, entry.Usage)
+	if useCache && !toolCalled(ctx) {
+		cacheResponse(key, model, response, entry.Usage)
+	}
+	return response, entry, nil
+}
+
+// structuredFields extracts the structured fields of an agent response, falling
+// back to decoding the text content as JSON
+func structuredFields(structured interface{}, content string) map[string]interface{} {
+	if fields, ok := structured.(map[string]interface{}); ok {
+		return fields
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(stripCodeFence(content)), &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// stripCodeFence removes a Markdown code fence the model may wrap JSON in
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	if newline := strings.Index(content, "\n"); newline >= 0 {
+		content = content[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
+
+// stringField returns the named field of a structured response as a string
+func stringField(fields map[string]interface{}, name string) string {
+	if value, ok := fields[name].(string); ok {
+		return value
+	}
+	return ""
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 	"runtime"
 
 	"github.com/spf13/cobra"
@@ -17,23 +19,52 @@ type model struct {
 func main() {
 
 	rootCmd := &cobra.Command{
-		Use:   "ai",
-		Short: "A CLI tool to execute commands",
+		Use:              "ai",
+		Short:            "A CLI tool to execute commands",
+		PersistentPreRun: setCurrentCommand,
 	}
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Ask the model again instead of using the response cache")
 
 	rootCmd.AddCommand(MakeCmd)
 
 	rootCmd.AddCommand(WriterCmd)
 
+	rootCmd.AddCommand(TranslateCmd)
+
 	rootCmd.AddCommand(GitCommitCmd)
 
+	rootCmd.AddCommand(BranchCmd)
+
+	rootCmd.AddCommand(PullRequestCmd)
+
+	rootCmd.AddCommand(ReviewCmd)
+
+	rootCmd.AddCommand(ChangelogCmd)
+
+	rootCmd.AddCommand(ExplainCmd)
+
+	rootCmd.AddCommand(ResolveCmd)
+
 	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
 		rootCmd.AddCommand(CoPilotCmd)
 	}
 
 	rootCmd.AddCommand(WebCmd)
 
+	rootCmd.AddCommand(McpCmd)
+
+	rootCmd.AddCommand(IndexCmd)
+
+	rootCmd.AddCommand(CacheCmd)
+
+	rootCmd.AddCommand(UsageCmd)
+
+	addRecipeCommands(rootCmd)
+
 	if err := rootCmd.Execute(); err != nil {
+		if errors.Is(err, errLintIssues) {
+			os.Exit(1)
+		}
 		log.Fatalf("Error executing root command: %v", err)
 	}
 }
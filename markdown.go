@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// markdownBlock is a run of lines of a Markdown document. Only prose blocks are
+// rewritten, everything else is copied through unchanged.
+type markdownBlock struct {
+	Text  string
+	Prose bool
+}
+
+var (
+	markdownFence     = regexp.MustCompile("^\\s{0,3}(```+|~~~+)")
+	markdownHeading   = regexp.MustCompile(`^\s{0,3}#{1,6}(\s|$)`)
+	markdownSetext    = regexp.MustCompile(`^\s{0,3}(=+|-+)\s*$`)
+	markdownRule      = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	markdownTable     = regexp.MustCompile(`^\s*\|`)
+	markdownHTML      = regexp.MustCompile(`^\s{0,3}<[a-zA-Z/!]`)
+	markdownRefDef    = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s`)
+	markdownProtected = regexp.MustCompile("`[^`]+`|!?\\[[^\\]]*\\]\\([^)]*\\)|!?\\[[^\\]]*\\]\\[[^\\]]*\\]|<https?://[^>]+>|https?://[^\\s)>\\]]*[^\\s)>\\].,;:!?]")
+	markdownToken     = regexp.MustCompile(`⟦\d+⟧`)
+)
+
+// parseMarkdown splits a document into prose paragraphs and preserved blocks:
+// front matter, fenced and indented code, rules, link reference definitions and
+// blank lines. With keepStructure headings, including setext headings
+// underlined with === or ---, tables and HTML are preserved too; without it
+// they are treated as prose (e.g. to be translated) and only the underline is kept.
+func parseMarkdown(content string, keepStructure bool) []markdownBlock {
+	lines := strings.SplitAfter(content, "\n")
+	var blocks []markdownBlock
+	var prose []string
+
+	flushProse := func() {
+		if len(prose) > 0 {
+			blocks = append(blocks, markdownBlock{Text: strings.Join(prose, ""), Prose: true})
+			prose = nil
+		}
+	}
+	preserve := func(text string) {
+		flushProse()
+		if n := len(blocks); n > 0 && !blocks[n-1].Prose {
+			blocks[n-1].Text += text
+			return
+		}
+		blocks = append(blocks, markdownBlock{Text: text})
+	}
+
+	i := 0
+
+	// Front matter must start on the first line
+	if len(lines) > 0 {
+		if delimiter := strings.TrimSpace(lines[0]); delimiter == "---" || delimiter == "+++" {
+			for end := 1; end < len(lines); end++ {
+				if strings.TrimSpace(lines[end]) == delimiter {
+					preserve(strings.Join(lines[:end+1], ""))
+					i = end + 1
+					break
+				}
+			}
+		}
+	}
+
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		if match := markdownFence.FindStringSubmatch(trimmed); match != nil {
+			fence := match[1]
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), fence) {
+				end++
+			}
+			if end == len(lines) {
+				end--
+			}
+			preserve(strings.Join(lines[i:end+1], ""))
+			i = end + 1
+			continue
+		}
+
+		// A paragraph followed by === or --- is a setext heading
+		if len(prose) > 0 && markdownSetext.MatchString(trimmed) {
+			if keepStructure {
+				heading := strings.Join(prose, "")
+				prose = nil
+				preserve(heading + line)
+			} else {
+				preserve(line)
+			}
+			i++
+			continue
+		}
+
+		indentedCode := (strings.HasPrefix(trimmed, "    ") || strings.HasPrefix(trimmed, "\t")) && len(prose) == 0
+		structure := markdownHeading.MatchString(trimmed) || markdownTable.MatchString(trimmed) ||
+			markdownHTML.MatchString(trimmed)
+		if strings.TrimSpace(trimmed) == "" || indentedCode || (keepStructure && structure) ||
+			markdownRule.MatchString(trimmed) || markdownRefDef.MatchString(trimmed) {
+			preserve(line)
+		} else {
+			prose = append(prose, line)
+		}
+		i++
+	}
+	flushProse()
+
+	return blocks
+}
+
+// protectMarkdown replaces inline code, links and URLs with numbered tokens so
+// the model cannot alter them
+func protectMarkdown(text string) (string, []string) {
+	return protectSpans(text, markdownProtected)
+}
+
+// protectSpans replaces every match of pattern with a numbered token
+func protectSpans(text string, pattern *regexp.Regexp) (string, []string) {
+	var spans []string
+	protected := pattern.ReplaceAllStringFunc(text, func(span string) string {
+		spans = append(spans, span)
+		return fmt.Sprintf("⟦%d⟧", len(spans)-1)
+	})
+	return protected, spans
+}
+
+// restoreMarkdown puts the protected spans back. It fails if the model dropped,
+// duplicated or invented a token.
+func restoreMarkdown(text string, spans []string) (string, error) {
+	tokens := markdownToken.FindAllString(text, -1)
+	if len(tokens) != len(spans) {
+		return "", fmt.Errorf("expected %d protected spans, found %d", len(spans), len(tokens))
+	}
+
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if seen[token] {
+			return "", fmt.Errorf("protected span %s appears twice", token)
+		}
+		seen[token] = true
+	}
+
+	for i, span := range spans {
+		token := fmt.Sprintf("⟦%d⟧", i)
+		if !seen[token] {
+			return "", fmt.Errorf("protected span %s is missing", token)
+		}
+		text = strings.Replace(text, token, span, 1)
+	}
+	return text, nil
+}
+
+// expandMarkdownTokens replaces the tokens in a fragment of protected text with
+// their spans, leaving unknown tokens as they are
+func expandMarkdownTokens(text string, spans []string) string {
+	return markdownToken.ReplaceAllStringFunc(text, func(token string) string {
+		var n int
+		if _, err := fmt.Sscanf(token, "⟦%d⟧", &n); err == nil && n >= 0 && n < len(spans) {
+			return spans[n]
+		}
+		return token
+	})
+}
+
+// splitTrailingNewlines separates a block from its line endings so that they
+// can be restored exactly after rewriting
+func splitTrailingNewlines(text string) (string, string) {
+	body := strings.TrimRight(text, "\r\n")
+	return body, text[len(body):]
+}
+
+// markdownChunkSize is the approximate number of characters of prose sent to
+// the model in one request
+const markdownChunkSize = 4000
+
+var markdownRevisionPrompt = `
+The user sends a JSON array of Markdown paragraphs taken from a longer document. Revise each
+paragraph on its own and reply with a JSON array of the revised paragraphs, in the same order
+and with exactly the same number of elements, and nothing else.
+- Keep every token of the form ⟦n⟧ exactly once and unchanged; they stand for code, links and URLs.
+- Keep Markdown syntax such as list markers, blockquote markers, emphasis and line breaks between list items.
+- Do not add headings, greetings, subject lines or signatures.`
+
+// reviseMarkdown rewrites the prose paragraphs of a Markdown document in chunks
+// and leaves code, links, front matter and headings untouched. Paragraphs the
+// model mangles are kept as they were and counted in the returned skipped total.
+func reviseMarkdown(content string, opts WriterOptions, progress func(done, total int)) (string, int, error) {
+	if opts.Tone == "" {
+		opts.Tone = "professional"
+	}
+	// Length and format apply to whole messages, not to paragraphs of a document
+	opts.Length, opts.Format = 0, ""
+
+	systemPrompt := writerSystemPrompt(opts) + markdownRevisionPrompt
+	return rewriteMarkdown(content, true, markdownProtected, systemPrompt, progress)
+}
+
+// rewriteMarkdown sends the prose paragraphs of a document to the model in
+// chunks, as JSON arrays, with the matches of pattern protected by tokens. The
+// system prompt must ask for a JSON array of the same length in reply.
+func rewriteMarkdown(content string, keepStructure bool, pattern *regexp.Regexp, systemPrompt string, progress func(done, total int)) (string, int, error) {
+	blocks := parseMarkdown(content, keepStructure)
+
+	var chunks [][]int
+	size := markdownChunkSize
+	for i, block := range blocks {
+		if !block.Prose {
+			continue
+		}
+		if size+len(block.Text) > markdownChunkSize {
+			chunks = append(chunks, nil)
+			size = 0
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], i)
+		size += len(block.Text)
+	}
+
+	skipped := 0
+	for n, chunk := range chunks {
+		if progress != nil {
+			progress(n, len(chunks))
+		}
+
+		paragraphs := make([]string, len(chunk))
+		spans := make([][]string, len(chunk))
+		indents := make([]string, len(chunk))
+		newlines := make([]string, len(chunk))
+		for i, index := range chunk {
+			body, trailing := splitTrailingNewlines(blocks[index].Text)
+			unindented := strings.TrimLeft(body, " \t")
+			indents[i] = body[:len(body)-len(unindented)] // e.g. of a nested list item
+			paragraphs[i], spans[i] = protectSpans(unindented, pattern)
+			newlines[i] = trailing
+		}
+
+		query, err := json.Marshal(paragraphs)
+		if err != nil {
+			return "", 0, err
+		}
+
+		var revised []string
+		if err := AskJSON(string(query), systemPrompt, &revised); err != nil {
+			return "", 0, err
+		}
+		if len(revised) != len(chunk) {
+			skipped += len(chunk)
+			continue
+		}
+
+		for i, index := range chunk {
+			text, err := restoreMarkdown(strings.TrimSpace(revised[i]), spans[i])
+			if err != nil {
+				skipped++
+				continue
+			}
+			blocks[index].Text = indents[i] + text + newlines[i]
+		}
+	}
+
+	var b strings.Builder
+	for _, block := range blocks {
+		b.WriteString(block.Text)
+	}
+	return b.String(), skipped, nil
+}
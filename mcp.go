@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/mux"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/ncruces/zenity"
+	"github.com/spf13/cobra"
+)
+
+// Limits of the run_command tool
+const (
+	mcpCommandTimeout   = 2 * time.Minute
+	mcpMaxCommandOutput = 64 * 1024
+)
+
+// McpCmd groups the Model Context Protocol commands
+var McpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Serve Gema's tools over MCP and list the MCP servers it uses",
+}
+
+// McpServeCmd exposes Gema's tools to other AI clients over MCP
+var McpServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an MCP server exposing Gema's tools",
+	Long: `Run a Model Context Protocol server so that other AI clients can call Gema's tools:
+get_system_info, generate_commit_message, revise_text, search_history and run_command.
+
+By default the server talks over stdin and stdout, which is how most clients launch
+servers. With --http it serves the streamable HTTP transport at /mcp instead, protected
+by the same bearer token as "ai web".
+
+run_command asks for confirmation before running anything: through the client when it
+supports elicitation, otherwise in a desktop dialog. Commands are refused when neither
+is available.`,
+	Args: cobra.NoArgs,
+	RunE: executeMcpServeCommand,
+}
+
+func init() {
+	McpServeCmd.Flags().String("http", "", "Serve over HTTP at this address (e.g. 127.0.0.1:8765) instead of stdio")
+	McpServeCmd.Flags().StringSlice("allow-origin", nil, "Extra origin allowed to call the HTTP server (repeatable)")
+	McpServeCmd.Flags().Bool("no-run-command", false, "Do not expose the run_command tool")
+	McpCmd.AddCommand(McpServeCmd)
+}
+
+func executeMcpServeCommand(cmd *cobra.Command, args []string) error {
+	addr, _ := cmd.Flags().GetString("http")
+	noRun, _ := cmd.Flags().GetBool("no-run-command")
+	server := newMcpServer(!noRun)
+
+	if addr == "" {
+		// stdout carries the protocol, so nothing else may be printed there
+		return server.Run(cmd.Context(), &mcp.StdioTransport{})
+	}
+
+	origins, _ := cmd.Flags().GetStringSlice("allow-origin")
+	return serveMcpHTTP(server, addr, origins)
+}
+
+// serveMcpHTTP serves the MCP server at /mcp until SIGINT or SIGTERM
+func serveMcpHTTP(server *mcp.Server, addr string, origins []string) error {
+	token, err := loadWebToken(false)
+	if err != nil {
+		return err
+	}
+
+	r := mux.NewRouter()
+	r.Use(newWebGuard(token, origins).Middleware)
+	r.Handle("/mcp", mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return server }, nil))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && !isLoopbackHost(host) {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: %s is reachable from other machines, anyone with the token can run tools\n", addr)
+	}
+	fmt.Fprintf(os.Stderr, "MCP server listening at http://%s/mcp\n", listener.Addr())
+	fmt.Fprintf(os.Stderr, "Token: %s\n", token)
+
+	httpServer := &http.Server{Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() { errs <- httpServer.Serve(listener) }()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+// newMcpServer returns an MCP server with Gema's tools
+func newMcpServer(allowRun bool) *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: "gema", Version: gemaVersion()}, nil)
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "get_system_info",
+		Description: "Get detailed system information including hardware, OS, memory usage, and running processes",
+	}, mcpSystemInfo)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "generate_commit_message",
+		Description: "Write a commit message for the uncommitted changes of a git repository",
+	}, mcpCommitMessage)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "revise_text",
+		Description: "Revise a text for tone, format, audience, language and length",
+	}, mcpReviseText)
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "search_history",
+		Description: "Search the questions and answers stored in Gema's history",
+	}, mcpSearchHistory)
+	if allowRun {
+		mcp.AddTool(server, &mcp.Tool{
+			Name:        "run_command",
+			Description: "Run a shell command on the user's machine after the user confirms it",
+		}, mcpRunCommand)
+	}
+
+	return server
+}
+
+// SystemInfoInput takes no arguments
+type SystemInfoInput struct{}
+
+// CommitMessageInput are the arguments of generate_commit_message
+type CommitMessageInput struct {
+	Path   string `json:"path,omitempty" jsonschema:"path of the repository, defaults to the server's working directory"`
+	Staged bool   `json:"staged,omitempty" jsonschema:"describe the staged changes instead of the unstaged ones"`
+	Prompt string `json:"prompt,omitempty" jsonschema:"custom instructions for the commit message"`
+}
+
+// ReviseTextInput are the arguments of revise_text
+type ReviseTextInput struct {
+	Text         string `json:"text" jsonschema:"the text to revise"`
+	Preset       string `json:"preset,omitempty" jsonschema:"writer preset from the config, overridden by the other options"`
+	Tone         string `json:"tone,omitempty" jsonschema:"professional, friendly, formal, concise or assertive"`
+	Format       string `json:"format,omitempty" jsonschema:"email, slack, tweet, pr-comment or bullet"`
+	Audience     string `json:"audience,omitempty" jsonschema:"exec, engineer or customer"`
+	Lang         string `json:"lang,omitempty" jsonschema:"language of the output"`
+	Length       int    `json:"length,omitempty" jsonschema:"approximate length in words"`
+	Instructions string `json:"instructions,omitempty" jsonschema:"extra free-form instructions"`
+}
+
+// SearchHistoryInput are the arguments of search_history
+type SearchHistoryInput struct {
+	Query string `json:"query" jsonschema:"text to look for in the questions and answers"`
+	Limit int    `json:"limit,omitempty" jsonschema:"maximum number of entries, 10 by default"`
+}
+
+// SearchHistoryOutput is the result of search_history
+type SearchHistoryOutput struct {
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// RunCommandInput are the arguments of run_command
+type RunCommandInput struct {
+	Command string `json:"command" jsonschema:"the shell command to run"`
+	Dir     string `json:"dir,omitempty" jsonschema:"working directory, defaults to the server's"`
+}
+
+func mcpSystemInfo(ctx context.Context, req *mcp.CallToolRequest, in SystemInfoInput) (*mcp.CallToolResult, any, error) {
+	info, err := GetSystemInfo(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mcpText(info), nil, nil
+}
+
+func mcpCommitMessage(ctx context.Context, req *mcp.CallToolRequest, in CommitMessageInput) (*mcp.CallToolResult, any, error) {
+	path := in.Path
+	if path == "" {
+		path = "."
+	}
+	if !IsGitRepo(path) {
+		return nil, nil, fmt.Errorf("%s is not a git repository", path)
+	}
+
+	var diffArgs []string
+	if in.Staged {
+		diffArgs = append(diffArgs, "--cached")
+	}
+	files, diff, err := GetDiff(path, diffArgs...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no changes to describe")
+	}
+
+	message, err := CommitMessageForDiff(ctx, files, diff, in.Prompt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mcpText(message), nil, nil
+}
+
+func mcpReviseText(ctx context.Context, req *mcp.CallToolRequest, in ReviseTextInput) (*mcp.CallToolResult, any, error) {
+	var opts WriterOptions
+	if in.Preset != "" {
+		preset, err := loadWriterPreset(in.Preset)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = preset
+	}
+	for _, field := range []struct {
+		value  string
+		target *string
+	}{
+		{in.Tone, &opts.Tone},
+		{in.Format, &opts.Format},
+		{in.Audience, &opts.Audience},
+		{in.Lang, &opts.Lang},
+		{in.Instructions, &opts.Instructions},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	if in.Length > 0 {
+		opts.Length = in.Length
+	}
+
+	revised, err := extractGeminiTextContext(ctx, in.Text, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mcpText(revised), nil, nil
+}
+
+func mcpSearchHistory(ctx context.Context, req *mcp.CallToolRequest, in SearchHistoryInput) (*mcp.CallToolResult, SearchHistoryOutput, error) {
+	limit := in.Limit
+	if limit <= 0 {
+		limit = 10
+	} else if limit > 100 {
+		limit = 100
+	}
+
+	storage, err := NewStorage()
+	if err != nil {
+		return nil, SearchHistoryOutput{}, err
+	}
+	defer storage.Close()
+
+	entries, err := storage.SearchCommands(in.Query, limit)
+	if err != nil {
+		return nil, SearchHistoryOutput{}, err
+	}
+	return nil, SearchHistoryOutput{Entries: entries}, nil
+}
+
+func mcpRunCommand(ctx context.Context, req *mcp.CallToolRequest, in RunCommandInput) (*mcp.CallToolResult, any, error) {
+	if in.Command == "" {
+		return nil, nil, fmt.Errorf("command is required")
+	}
+
+	confirmed, err := confirmMcpCommand(ctx, req.Session, in.Command, in.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !confirmed {
+		return nil, nil, fmt.Errorf("the user declined to run the command")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mcpCommandTimeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, "bash", "-c", in.Command)
+	command.Dir = in.Dir
+	output, err := command.CombinedOutput()
+	if len(output) > mcpMaxCommandOutput {
+		output = append(output[:mcpMaxCommandOutput], "\n... output truncated"...)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, nil, fmt.Errorf("command timed out after %s:\n%s", mcpCommandTimeout, output)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("command failed: %v\n%s", err, output)
+	}
+	return mcpText(string(output)), nil, nil
+}
+
+// confirmMcpCommand asks the user whether a command may run, through the
+// client when it supports elicitation and in a desktop dialog otherwise
+func confirmMcpCommand(ctx context.Context, session *mcp.ServerSession, command, dir string) (bool, error) {
+	message := "An AI client wants to run this command:\n\n" + command
+	if dir != "" {
+		message += "\n\nin " + dir
+	}
+
+	if params := session.InitializeParams(); params != nil && params.Capabilities != nil && params.Capabilities.Elicitation != nil {
+		result, err := session.Elicit(ctx, &mcp.ElicitParams{
+			Message: message,
+			RequestedSchema: json.RawMessage(`{"type": "object", "properties": {"confirm": ` +
+				`{"type": "boolean", "title": "Run the command"}}, "required": ["confirm"]}`),
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to ask for confirmation: %w", err)
+		}
+		confirmed, _ := result.Content["confirm"].(bool)
+		return result.Action == "accept" && confirmed, nil
+	}
+
+	err := zenity.Question(message,
+		zenity.Context(ctx),
+		zenity.Title("Gema MCP"),
+		zenity.OKLabel("Run"),
+		zenity.CancelLabel("Cancel"),
+		zenity.WarningIcon,
+	)
+	if errors.Is(err, zenity.ErrCanceled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot ask for confirmation, the client does not support elicitation and no dialog could be shown: %w", err)
+	}
+	return true, nil
+}
+
+// mcpText returns a tool result holding a text
+func mcpText(text string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: text}}}
+}
+
+// gemaVersion returns the module version of the binary, or "dev" for local builds
+func gemaVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
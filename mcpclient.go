@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/spf13/cobra"
+)
+
+// Timeouts of the MCP servers declared in the config
+const (
+	mcpConnectTimeout     = 15 * time.Second
+	defaultMCPCallTimeout = time.Minute
+)
+
+// mcpToolNameChars matches the characters not allowed in the tool names given to the model
+var mcpToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// McpListCmd shows the tools of the configured MCP servers
+var McpListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the configured MCP servers and the tools the assistant may use",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		if len(config.MCP.Servers) == 0 {
+			fmt.Println("No MCP servers are configured, add them under mcp.servers in ~/.gema/config.yaml")
+			return nil
+		}
+
+		for _, server := range connectMCPServers(config.MCP.Servers) {
+			if server.err != nil {
+				color.New(color.FgRed, color.Bold).Printf("%s: %v\n", server.name, server.err)
+				continue
+			}
+			color.New(color.FgBlue, color.Bold).Printf("%s (%d tools)\n", server.name, len(server.tools))
+			for _, tool := range server.tools {
+				switch {
+				case !server.allows(tool.Name):
+					color.New(color.FgHiBlack).Printf("  ✗ %-30s denied\n", tool.Name)
+				case matchesAny(server.config.AutoApprove, tool.Name):
+					color.New(color.FgGreen).Printf("  ✓ %-30s auto-approved as %s\n", tool.Name, mcpToolName(server.name, tool.Name))
+				default:
+					color.New(color.FgYellow).Printf("  ✓ %-30s asks first, as %s\n", tool.Name, mcpToolName(server.name, tool.Name))
+				}
+			}
+			server.session.Close()
+		}
+		return nil
+	},
+}
+
+func init() {
+	McpCmd.AddCommand(McpListCmd)
+}
+
+// mcpServer is a connected MCP server and the tools it offers
+type mcpServer struct {
+	name    string
+	config  MCPServerConfig
+	session *mcp.ClientSession
+	tools   []*mcp.Tool
+	err     error // why the server could not be used
+}
+
+var (
+	mcpServersOnce sync.Once
+	mcpServers     []*mcpServer
+)
+
+// loadMCPServers connects to the MCP servers of the config the first time it
+// is called. Servers that fail are reported once and skipped.
+func loadMCPServers() []*mcpServer {
+	mcpServersOnce.Do(func() {
+		config, err := LoadConfig()
+		if err != nil {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: MCP servers are disabled: %v\n", err)
+			return
+		}
+		mcpServers = connectMCPServers(config.MCP.Servers)
+		for _, server := range mcpServers {
+			if server.err != nil {
+				color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: MCP server %s is unavailable: %v\n", server.name, server.err)
+			}
+		}
+	})
+	return mcpServers
+}
+
+// connectMCPServers connects to the given servers in parallel and lists their tools
+func connectMCPServers(configs map[string]MCPServerConfig) []*mcpServer {
+	servers := make([]*mcpServer, 0, len(configs))
+	for name, config := range configs {
+		servers = append(servers, &mcpServer{name: name, config: config})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].name < servers[j].name })
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *mcpServer) {
+			defer wg.Done()
+			server.err = server.connect()
+		}(server)
+	}
+	wg.Wait()
+	return servers
+}
+
+// connect starts or dials the server and lists its tools
+func (s *mcpServer) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+	defer cancel()
+
+	var transport mcp.Transport
+	switch {
+	case s.config.Command != "" && s.config.URL != "":
+		return fmt.Errorf("set either command or url, not both")
+	case s.config.Command != "":
+		command := exec.Command(s.config.Command, s.config.Args...)
+		command.Env = os.Environ()
+		for key, value := range s.config.Env {
+			command.Env = append(command.Env, key+"="+os.ExpandEnv(value))
+		}
+		transport = &mcp.CommandTransport{Command: command}
+	case s.config.URL != "":
+		headers := make(http.Header)
+		for key, value := range s.config.Headers {
+			headers.Set(key, os.ExpandEnv(value))
+		}
+		transport = &mcp.StreamableClientTransport{
+			Endpoint:   s.config.URL,
+			HTTPClient: &http.Client{Transport: headerTransport{headers: headers, base: http.DefaultTransport}},
+		}
+	default:
+		return fmt.Errorf("a command or a url is required")
+	}
+
+	return s.connectTransport(ctx, transport)
+}
+
+// connectTransport opens a session over transport and lists the tools of the server
+func (s *mcpServer) connectTransport(ctx context.Context, transport mcp.Transport) error {
+	client := mcp.NewClient(&mcp.Implementation{Name: "gema", Version: gemaVersion()}, nil)
+	session, err := client.Connect(ctx, transport, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	for tool, err := range session.Tools(ctx, nil) {
+		if err != nil {
+			session.Close()
+			return fmt.Errorf("failed to list tools: %w", err)
+		}
+		s.tools = append(s.tools, tool)
+	}
+	s.session = session
+	return nil
+}
+
+// allows reports whether the config lets the assistant use a tool
+func (s *mcpServer) allows(tool string) bool {
+	if matchesAny(s.config.Deny, tool) {
+		return false
+	}
+	return len(s.config.Allow) == 0 || matchesAny(s.config.Allow, tool)
+}
+
+// call calls a tool of the server, after asking the user unless the tool is
+// auto-approved
+func (s *mcpServer) call(ctx context.Context, tool string, args map[string]interface{}) (interface{}, error) {
+	if !matchesAny(s.config.AutoApprove, tool) {
+		confirm := toolConfirmer(ctx)
+		if confirm == nil {
+			return nil, fmt.Errorf("calling %s on MCP server %s needs a confirmation that cannot be asked here, add it to auto_approve to allow it", tool, s.name)
+		}
+		if !confirm(tool, "the "+s.name+" MCP server", args) {
+			return nil, fmt.Errorf("the user declined the call to %s", tool)
+		}
+	}
+
+	timeout := s.config.Timeout
+	if timeout <= 0 {
+		timeout = defaultMCPCallTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result, err := s.session.CallTool(ctx, &mcp.CallToolParams{Name: tool, Arguments: args})
+	if err != nil {
+		return nil, fmt.Errorf("MCP server %s: %w", s.name, err)
+	}
+
+	text := mcpResultText(result)
+	if result.IsError {
+		return nil, fmt.Errorf("%s failed: %s", tool, text)
+	}
+	if result.StructuredContent != nil {
+		return result.StructuredContent, nil
+	}
+	return text, nil
+}
+
+// registerMCPTools adds the allowed tools of the configured MCP servers to an
+// agent and returns their names, which are prefixed with the server name as in
+// jira__search
+func registerMCPTools(ctx context.Context, agent *sapiens.Agent) []string {
+	taken := map[string]bool{"get_system_info": true}
+	for _, tool := range loadCustomTools() {
+		taken[tool.Name] = true
+	}
+
+	var names []string
+	for _, tool := range mcpAgentTools(loadMCPServers(), taken) {
+		tool := tool
+		agentTool := sapiens.Tool{
+			Name:        tool.name,
+			Description: fmt.Sprintf("%s (from the %s MCP server)", tool.tool.Description, tool.server.name),
+		}
+		setToolInputSchema(&agentTool, sapiensSchema(tool.tool.InputSchema))
+		agent.AddTools(agentTool)
+		agent.RegisterToolImplementation(tool.name, func(params map[string]interface{}) (interface{}, error) {
+			noteToolCall(ctx)
+			return tool.server.call(ctx, tool.tool.Name, params)
+		})
+		names = append(names, tool.name)
+	}
+	return names
+}
+
+// mcpAgentTool is a tool of an MCP server and the name the model sees for it
+type mcpAgentTool struct {
+	name   string
+	server *mcpServer
+	tool   *mcp.Tool
+}
+
+// mcpSkippedTools remembers the tools already reported as skipped
+var mcpSkippedTools sync.Map
+
+// mcpAgentTools returns the allowed tools of the servers. Tools whose name is
+// taken, by another tool or by a name in taken, are reported once and skipped.
+func mcpAgentTools(servers []*mcpServer, taken map[string]bool) []mcpAgentTool {
+	used := make(map[string]bool, len(taken))
+	for name := range taken {
+		used[name] = true
+	}
+
+	var tools []mcpAgentTool
+	for _, server := range servers {
+		if server.err != nil {
+			continue
+		}
+		for _, tool := range server.tools {
+			if !server.allows(tool.Name) {
+				continue
+			}
+			name := mcpToolName(server.name, tool.Name)
+			if used[name] {
+				if _, reported := mcpSkippedTools.LoadOrStore(server.name+"/"+tool.Name, true); !reported {
+					color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: skipping %s of MCP server %s: the name %s is already used\n", tool.Name, server.name, name)
+				}
+				continue
+			}
+			used[name] = true
+			tools = append(tools, mcpAgentTool{name: name, server: server, tool: tool})
+		}
+	}
+	return tools
+}
+
+// mcpToolName returns the name the model sees for a tool of a server
+func mcpToolName(server, tool string) string {
+	name := mcpToolNameChars.ReplaceAllString(server+"__"+tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// jsonSchema is the part of a JSON schema the agent's tool schemas can express
+type jsonSchema struct {
+	Type        json.RawMessage       `json:"type"` // a type or a list of types
+	Description string                `json:"description"`
+	Properties  map[string]jsonSchema `json:"properties"`
+	Required    []string              `json:"required"`
+	Items       *jsonSchema           `json:"items"` // the elements of an array
+}
+
+// sapiensSchema converts the input schema of an MCP tool to an agent schema
+func sapiensSchema(schema any) *sapiens.Schema {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var parsed jsonSchema
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil
+	}
+	converted := parsed.convert()
+	return &converted
+}
+
+func (s jsonSchema) convert() sapiens.Schema {
+	converted := sapiens.Schema{Description: s.Description, Required: s.Required}
+
+	var types []string
+	if err := json.Unmarshal(s.Type, &converted.Type); err != nil && json.Unmarshal(s.Type, &types) == nil {
+		// nullable values list "null" next to their type
+		for _, t := range types {
+			if t != "null" {
+				converted.Type = t
+				break
+			}
+		}
+	}
+	if converted.Type == "" && len(s.Properties) > 0 {
+		converted.Type = "object"
+	}
+
+	if s.Items != nil {
+		items := s.Items.convert()
+		setSchemaItems(&converted, &items)
+	} else if converted.Type == "array" {
+		// the model needs to know what an array holds
+		setSchemaItems(&converted, &sapiens.Schema{Type: "string"})
+	}
+
+	if len(s.Properties) > 0 {
+		converted.Properties = make(map[string]sapiens.Schema, len(s.Properties))
+		for name, property := range s.Properties {
+			converted.Properties[name] = property.convert()
+		}
+	}
+	return converted
+}
+
+// mcpResultText joins the content of a tool result into a text
+func mcpResultText(result *mcp.CallToolResult) string {
+	var parts []string
+	for _, content := range result.Content {
+		switch content := content.(type) {
+		case *mcp.TextContent:
+			parts = append(parts, content.Text)
+		case *mcp.ImageContent:
+			parts = append(parts, "[image "+content.MIMEType+"]")
+		case *mcp.ResourceLink:
+			parts = append(parts, "[resource "+content.URI+"]")
+		case *mcp.EmbeddedResource:
+			if content.Resource != nil && content.Resource.Text != "" {
+				parts = append(parts, content.Resource.Text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// matchesAny reports whether a name matches one of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTransport adds the configured headers to the requests sent to an MCP server
+type headerTransport struct {
+	headers http.Header
+	base    http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	for key, values := range t.headers {
+		r.Header[key] = values
+	}
+	return t.base.RoundTrip(r)
+}
+
+// ToolConfirmer asks the user whether the assistant may call a tool. Source
+// tells where the tool comes from, e.g. "the jira MCP server".
+type ToolConfirmer func(tool, source string, args map[string]interface{}) bool
+
+type toolConfirmerKey struct{}
+
+// withToolConfirmer returns a context whose tool calls are confirmed by confirm
+func withToolConfirmer(ctx context.Context, confirm ToolConfirmer) context.Context {
+	return context.WithValue(ctx, toolConfirmerKey{}, confirm)
+}
+
+// toolConfirmer returns the confirmer of a context, or nil when calls cannot be confirmed
+func toolConfirmer(ctx context.Context) ToolConfirmer {
+	confirm, _ := ctx.Value(toolConfirmerKey{}).(ToolConfirmer)
+	return confirm
+}
+
+// confirmOnTerminal asks for confirmation on the terminal
+func confirmOnTerminal(tool, source string, args map[string]interface{}) bool {
+	terminalMu.Lock()
+	defer terminalMu.Unlock()
+
+	encoded, _ := json.MarshalIndent(args, "", "  ")
+	color.New(color.FgYellow).Printf("\rThe assistant wants to call %s from %s with:\n%s\n", tool, source, encoded)
+	color.New(color.FgYellow).Print("Allow it? (y for yes, n for no): ")
+	var input string
+	fmt.Scanln(&input)
+	return input == "y"
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type stubIssueArgs struct {
+	Query string   `json:"query"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// startStubMCPServer runs an in-process MCP server with a few issue tools and
+// returns a connected mcpServer using config
+func startStubMCPServer(t *testing.T, name string, config MCPServerConfig) *mcpServer {
+	t.Helper()
+	ctx := context.Background()
+
+	server := mcp.NewServer(&mcp.Implementation{Name: "stub", Version: "1.0.0"}, nil)
+	for _, tool := range []string{"search_issues", "create_issue", "delete_issue"} {
+		tool := tool
+		mcp.AddTool(server, &mcp.Tool{Name: tool, Description: "Stub " + tool}, func(ctx context.Context, req *mcp.CallToolRequest, in stubIssueArgs) (*mcp.CallToolResult, any, error) {
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: tool + ": " + in.Query}}}, nil, nil
+		})
+	}
+
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := server.Connect(ctx, serverTransport, nil)
+	if err != nil {
+		t.Fatalf("server.Connect: %v", err)
+	}
+	t.Cleanup(func() { serverSession.Close() })
+
+	s := &mcpServer{name: name, config: config}
+	if err := s.connectTransport(ctx, clientTransport); err != nil {
+		t.Fatalf("connectTransport: %v", err)
+	}
+	t.Cleanup(func() { s.session.Close() })
+	return s
+}
+
+func agentToolNames(tools []mcpAgentTool) []string {
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.name)
+	}
+	return names
+}
+
+func TestMCPToolName(t *testing.T) {
+	tests := []struct {
+		server, tool, want string
+	}{
+		{"jira", "search", "jira__search"},
+		{"my.server", "get/issue", "my_server__get_issue"},
+		{"gh", strings.Repeat("x", 80), "gh__" + strings.Repeat("x", 60)},
+	}
+	for _, tt := range tests {
+		if got := mcpToolName(tt.server, tt.tool); got != tt.want {
+			t.Errorf("mcpToolName(%q, %q) = %q, want %q", tt.server, tt.tool, got, tt.want)
+		}
+	}
+}
+
+func TestMCPAgentToolsFiltering(t *testing.T) {
+	tests := []struct {
+		name   string
+		config MCPServerConfig
+		taken  map[string]bool
+		want   []string
+	}{
+		{
+			name: "all tools by default",
+			want: []string{"jira__create_issue", "jira__delete_issue", "jira__search_issues"},
+		},
+		{
+			name:   "allow list",
+			config: MCPServerConfig{Allow: []string{"search_*", "create_issue"}},
+			want:   []string{"jira__create_issue", "jira__search_issues"},
+		},
+		{
+			name:   "deny wins over allow",
+			config: MCPServerConfig{Allow: []string{"*"}, Deny: []string{"delete_*"}},
+			want:   []string{"jira__create_issue", "jira__search_issues"},
+		},
+		{
+			name:  "names taken by other tools are skipped",
+			taken: map[string]bool{"get_system_info": true, "jira__create_issue": true},
+			want:  []string{"jira__delete_issue", "jira__search_issues"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startStubMCPServer(t, "jira", tt.config)
+			got := agentToolNames(mcpAgentTools([]*mcpServer{server}, tt.taken))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tools = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMCPAgentToolsSkipsDuplicateNames(t *testing.T) {
+	first := startStubMCPServer(t, "a.b", MCPServerConfig{Allow: []string{"search_issues"}})
+	second := startStubMCPServer(t, "a_b", MCPServerConfig{Allow: []string{"search_issues"}})
+	broken := &mcpServer{name: "down", err: context.DeadlineExceeded}
+
+	got := agentToolNames(mcpAgentTools([]*mcpServer{first, second, broken}, nil))
+	if want := []string{"a_b__search_issues"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tools = %v, want %v", got, want)
+	}
+}
+
+func TestMCPServerCallConfirmation(t *testing.T) {
+	server := startStubMCPServer(t, "jira", MCPServerConfig{AutoApprove: []string{"search_*"}})
+	args := map[string]interface{}{"query": "login bug"}
+
+	// auto-approved tools need no confirmer
+	got, err := server.call(context.Background(), "search_issues", args)
+	if err != nil || got != "search_issues: login bug" {
+		t.Errorf("auto-approved call = %v, %v", got, err)
+	}
+
+	// other tools are refused when nobody can confirm them
+	if _, err := server.call(context.Background(), "create_issue", args); err == nil || !strings.Contains(err.Error(), "auto_approve") {
+		t.Errorf("call without confirmer: err = %v, want a refusal", err)
+	}
+
+	var asked []string
+	confirm := func(answer bool) ToolConfirmer {
+		return func(tool, source string, args map[string]interface{}) bool {
+			asked = append(asked, tool+" from "+source+" for "+args["query"].(string))
+			return answer
+		}
+	}
+
+	if _, err := server.call(withToolConfirmer(context.Background(), confirm(false)), "create_issue", args); err == nil || !strings.Contains(err.Error(), "declined") {
+		t.Errorf("declined call: err = %v, want a decline", err)
+	}
+	got, err = server.call(withToolConfirmer(context.Background(), confirm(true)), "create_issue", args)
+	if err != nil || got != "create_issue: login bug" {
+		t.Errorf("confirmed call = %v, %v", got, err)
+	}
+
+	want := []string{
+		"create_issue from the jira MCP server for login bug",
+		"create_issue from the jira MCP server for login bug",
+	}
+	if !reflect.DeepEqual(asked, want) {
+		t.Errorf("confirmations = %q, want %q", asked, want)
+	}
+}
+
+// arrayOf returns the schema of an array of items
+func arrayOf(items sapiens.Schema) sapiens.Schema {
+	schema := sapiens.Schema{Type: "array"}
+	setSchemaItems(&schema, &items)
+	return schema
+}
+
+func TestSapiensSchema(t *testing.T) {
+	input := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"query":    map[string]any{"type": "string", "description": "What to look for"},
+			"assignee": map[string]any{"type": []any{"null", "string"}},
+			"tags":     map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"ids":      map[string]any{"type": "array"},
+			"filter": map[string]any{
+				"properties": map[string]any{"open": map[string]any{"type": "boolean"}},
+			},
+			"links": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"url": map[string]any{"type": "string"}},
+					"required":   []any{"url"},
+				},
+			},
+		},
+		"required": []any{"query"},
+	}
+
+	want := &sapiens.Schema{
+		Type:     "object",
+		Required: []string{"query"},
+		Properties: map[string]sapiens.Schema{
+			"query":    {Type: "string", Description: "What to look for"},
+			"assignee": {Type: "string"},
+			"tags":     arrayOf(sapiens.Schema{Type: "string"}),
+			"ids":      arrayOf(sapiens.Schema{Type: "string"}),
+			"filter": {
+				Type:       "object",
+				Properties: map[string]sapiens.Schema{"open": {Type: "boolean"}},
+			},
+			"links": arrayOf(sapiens.Schema{
+				Type:       "object",
+				Properties: map[string]sapiens.Schema{"url": {Type: "string"}},
+				Required:   []string{"url"},
+			}),
+		},
+	}
+
+	if got := sapiensSchema(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("sapiensSchema() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// chatStreamKeepAlive is how often a comment is sent on a stream while the
+// model is still working, so that clients and proxies keep the connection open
+const chatStreamKeepAlive = 10 * time.Second
+
+// chatStreamChunkSize is the approximate number of characters per streamed chunk
+const chatStreamChunkSize = 48
+
+// ChatCompletionRequest is the body of POST /v1/chat/completions. Only the
+// fields the gateway uses are decoded; sampling parameters are ignored.
+type ChatCompletionRequest struct {
+	Model         string                  `json:"model"`
+	Messages      []ChatCompletionMessage `json:"messages"`
+	Stream        bool                    `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// ChatCompletionMessage is a message of a chat completion request. Content is
+// a string or an array of content parts.
+type ChatCompletionMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// chatContentPart is an element of an array message content
+type chatContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// ChatCompletionResponse is the body of a non-streaming chat completion
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   ChatCompletionUsage    `json:"usage"`
+}
+
+// ChatCompletionChoice is the answer of a non-streaming chat completion
+type ChatCompletionChoice struct {
+	Index        int                `json:"index"`
+	Message      ChatCompletionText `json:"message"`
+	FinishReason string             `json:"finish_reason"`
+}
+
+// ChatCompletionText is a message of a chat completion response
+type ChatCompletionText struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// ChatCompletionChunk is one server-sent event of a streaming chat completion
+type ChatCompletionChunk struct {
+	ID      string                `json:"id"`
+	Object  string                `json:"object"`
+	Created int64                 `json:"created"`
+	Model   string                `json:"model"`
+	Choices []ChatCompletionDelta `json:"choices"`
+	Usage   *ChatCompletionUsage  `json:"usage,omitempty"`
+}
+
+// ChatCompletionDelta is the part of the answer carried by a chunk
+type ChatCompletionDelta struct {
+	Index        int                `json:"index"`
+	Delta        ChatCompletionText `json:"delta"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+// ChatCompletionUsage reports the tokens used by a chat completion
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// registerOpenAIRoutes mounts the OpenAI-compatible endpoints on the router
+func registerOpenAIRoutes(r *mux.Router, limit func(http.HandlerFunc) http.HandlerFunc) {
+	r.HandleFunc("/v1/models", openAIModelsHandler).Methods("GET")
+	r.HandleFunc("/v1/chat/completions", limit(chatCompletionsHandler)).Methods("POST")
+}
+
+// openAIModelsHandler lists the configured model, which every request is sent to
+func openAIModelsHandler(w http.ResponseWriter, r *http.Request) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	writeAPIJSON(w, http.StatusOK, struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}{"list", []model{{ID: os.Getenv("GENAI_DEFAULT_MODEL"), Object: "model", OwnedBy: "gema"}}})
+}
+
+// chatCompletionsHandler answers an OpenAI chat completion with the configured
+// provider, the assistant's system prompt and its tools
+func chatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	var request ChatCompletionRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+
+	query, instructions, images, err := chatCompletionPrompt(request.Messages)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error())
+		return
+	}
+
+	ctx, meter := withUsageMeter(r.Context())
+	completion := chatCompletion{
+		ID:      "chatcmpl-" + randomHex(12),
+		Created: time.Now().Unix(),
+		Model:   os.Getenv("GENAI_DEFAULT_MODEL"),
+	}
+
+	if request.Stream {
+		completion.stream(ctx, w, r, query, instructions, images, request.StreamOptions.IncludeUsage, meter)
+		return
+	}
+
+	content, err := ChatContext(ctx, query, instructions, images)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, ChatCompletionResponse{
+		ID:      completion.ID,
+		Object:  "chat.completion",
+		Created: completion.Created,
+		Model:   completion.Model,
+		Choices: []ChatCompletionChoice{{
+			Message:      ChatCompletionText{Role: "assistant", Content: content},
+			FinishReason: "stop",
+		}},
+		Usage: chatUsage(meter.Usage()),
+	})
+}
+
+// chatCompletion holds the fields shared by the chunks of a completion
+type chatCompletion struct {
+	ID      string
+	Created int64
+	Model   string
+}
+
+// stream sends the answer as server-sent events. The provider answers in one
+// piece, so keep-alive comments are sent while it works and the answer is then
+// split into chunks. The headers wait for the answer or the first keep-alive,
+// so that cached answers are still marked and early errors get a status code.
+func (c chatCompletion) stream(ctx context.Context, w http.ResponseWriter, r *http.Request, query, instructions string,
+	images []ImageAttachment, includeUsage bool, meter *usageMeter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "streaming is not supported by this connection")
+		return
+	}
+
+	send := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	chunk := func(delta ChatCompletionText, finishReason *string) ChatCompletionChunk {
+		return ChatCompletionChunk{
+			ID: c.ID, Object: "chat.completion.chunk", Created: c.Created, Model: c.Model,
+			Choices: []ChatCompletionDelta{{Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	// The model's goroutine may report a cache hit while the headers are sent
+	var headerMu sync.Mutex
+	started := false
+	start := func() {
+		headerMu.Lock()
+		defer headerMu.Unlock()
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		send(chunk(ChatCompletionText{Role: "assistant"}, nil))
+	}
+	ctx = withCacheHitReporter(ctx, func(age time.Duration) {
+		headerMu.Lock()
+		defer headerMu.Unlock()
+		if !started {
+			setCacheHitHeaders(w, age)
+		}
+	})
+
+	type result struct {
+		content string
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		content, err := ChatContext(ctx, query, instructions, images)
+		done <- result{content, err}
+	}()
+
+	ticker := time.NewTicker(chatStreamKeepAlive)
+	defer ticker.Stop()
+
+	var answer result
+	for waiting := true; waiting; {
+		select {
+		case answer = <-done:
+			waiting = false
+		case <-ticker.C:
+			start()
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+
+	if answer.err != nil {
+		if r.Context().Err() == context.Canceled {
+			return
+		}
+		if !started {
+			writeModelError(w, r, answer.err)
+			return
+		}
+		code, message := errCodeInternal, answer.err.Error()
+		if r.Context().Err() == context.DeadlineExceeded {
+			code, message = errCodeTimeout, "the model did not answer in time"
+		}
+		send(APIError{Error: APIErrorDetail{Code: code, Message: message}})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+		return
+	}
+
+	start()
+	for _, piece := range splitChatStream(answer.content, chatStreamChunkSize) {
+		send(chunk(ChatCompletionText{Content: piece}, nil))
+	}
+	stop := "stop"
+	send(chunk(ChatCompletionText{}, &stop))
+
+	if includeUsage {
+		usage := chatUsage(meter.Usage())
+		send(ChatCompletionChunk{
+			ID: c.ID, Object: "chat.completion.chunk", Created: c.Created, Model: c.Model,
+			Choices: []ChatCompletionDelta{}, Usage: &usage,
+		})
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// chatCompletionPrompt turns the messages of a request into a query for the
+// assistant: system messages become instructions, earlier turns become the
+// conversation history and the last user message is the question
+func chatCompletionPrompt(messages []ChatCompletionMessage) (string, string, []ImageAttachment, error) {
+	last := -1
+	for i, message := range messages {
+		if message.Role == "user" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return "", "", nil, fmt.Errorf("messages must contain a user message")
+	}
+
+	var instructions []string
+	var history []ChatTurn
+	var question string
+	var images []ImageAttachment
+	for i, message := range messages {
+		text, messageImages, err := chatMessageContent(message.Content)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("message %d: %w", i, err)
+		}
+
+		switch {
+		case message.Role == "system" || message.Role == "developer":
+			instructions = append(instructions, text)
+		case i == last:
+			question, images = text, messageImages
+		case i < last && (message.Role == "user" || message.Role == "assistant"):
+			history = append(history, ChatTurn{Role: message.Role, Content: text})
+		}
+	}
+
+	return formatHistoryPrompt(history, question), strings.Join(instructions, "\n\n"), images, nil
+}
+
+// chatMessageContent returns the text and images of a message content, which
+// is either a string or an array of text and image_url parts
+func chatMessageContent(content json.RawMessage) (string, []ImageAttachment, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil, nil
+	}
+
+	var parts []chatContentPart
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", nil, fmt.Errorf("content must be a string or an array of parts")
+	}
+
+	var texts []string
+	var images []ImageAttachment
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			image, err := decodeDataURL(part.ImageURL.URL)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, image)
+		default:
+			return "", nil, fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+// decodeDataURL decodes a base64 data: URL holding an image
+func decodeDataURL(url string) (ImageAttachment, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !strings.HasPrefix(url, "data:") || !ok || !strings.HasSuffix(header, ";base64") {
+		return ImageAttachment{}, fmt.Errorf("images must be base64 data: URLs")
+	}
+
+	mimeType := strings.TrimSuffix(header, ";base64")
+	if !strings.HasPrefix(mimeType, "image/") {
+		return ImageAttachment{}, fmt.Errorf("unsupported image type %q", mimeType)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return ImageAttachment{}, fmt.Errorf("invalid base64 image data: %w", err)
+	}
+	return ImageAttachment{Data: decoded, MimeType: mimeType}, nil
+}
+
+// splitChatStream splits text into chunks of about size characters, breaking
+// after whitespace so that words are not cut. Text without whitespace, such as
+// Chinese or a long URL, is cut every 2*size characters.
+func splitChatStream(text string, size int) []string {
+	var chunks []string
+	start, count := 0, 0
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+		i += width
+		count++
+		if (count >= size && unicode.IsSpace(r)) || count >= 2*size {
+			chunks = append(chunks, text[start:i])
+			start, count = i, 0
+		}
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+// chatUsage converts token usage to the OpenAI format
+func chatUsage(usage TokenUsage) ChatCompletionUsage {
+	total := usage.TotalTokens
+	if total == 0 {
+		total = usage.PromptTokens + usage.CompletionTokens
+	}
+	return ChatCompletionUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      total,
+	}
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// prTemplatePaths lists the locations GitHub looks for a pull request template
+var prTemplatePaths = []string{
+	".github/pull_request_template.md",
+	".github/PULL_REQUEST_TEMPLATE.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"pull_request_template.md",
+	"docs/pull_request_template.md",
+	"docs/PULL_REQUEST_TEMPLATE.md",
+}
+
+// PullRequestCmd represents the pull request description command
+var PullRequestCmd = &cobra.Command{
+	Use:     "pr [base]",
+	Aliases: []string{"pull-request"},
+	Short:   "Generate an AI pull request title and description",
+	Long: `Generate a pull request title and description from the commits and the diff
+between the current branch and its base branch. The base defaults to the remote's
+default branch (or main/master). If the repository has a pull request template the
+description follows its sections.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		base := DefaultBaseBranch(path)
+		if len(args) > 0 {
+			base = args[0]
+		}
+
+		processingMsg := color.New(color.FgYellow).PrintFunc()
+		processingMsg(fmt.Sprintf("Describing changes against %s...\n", base))
+
+		title, body, err := GeneratePullRequest(path, base)
+		if err != nil {
+			return err
+		}
+
+		description := fmt.Sprintf("# %s\n\n%s\n", title, body)
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Println(description)
+			return nil
+		}
+
+		if err := os.WriteFile(output, []byte(description), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		color.Green("Pull request description written to %s", output)
+		return nil
+	},
+}
+
+func init() {
+	PullRequestCmd.Flags().StringP("output", "o", "", "Write the description to a file instead of stdout")
+	PullRequestCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// GeneratePullRequest generates a pull request title and body for the commits
+// between base and HEAD
+func GeneratePullRequest(path, base string) (string, string, error) {
+	commits, err := GitOutput(path, "log", "--no-merges", "--format=%h %s%n%b", base+"..HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(commits) == "" {
+		return "", "", fmt.Errorf("no commits found between %s and HEAD", base)
+	}
+
+	changedFiles, diff, err := GetDiff(path, base+"...HEAD")
+	if err != nil {
+		return "", "", err
+	}
+
+	bodyInstructions := `The body is Markdown with the sections "## Summary", "## Changes", "## Testing" and "## Risks".`
+	if template := findPRTemplate(path); template != "" {
+		bodyInstructions = "The body must fill in the repository's pull request template below, keeping its headings and checklists:\n\n" + template
+	}
+
+	systemPrompt := fmt.Sprintf(`You write pull request descriptions for software changes.
+Given the commit log and diff of a branch, return a short imperative title (under 72 characters)
+and a body for reviewers. %s
+Testing notes describe how the change was or should be verified. Risks list anything reviewers
+should look at closely; write "None identified" if there are none.`, bodyInstructions)
+
+	query := fmt.Sprintf("Commits:\n%s\n\nChanged files:\n%s\n\nDiff:\n%s",
+		commits,
+		strings.Join(changedFiles, "\n"),
+		limitDiffSize(diff, 12000))
+
+	schema := sapiens.Schema{
+		Type: "object",
+		Properties: map[string]sapiens.Schema{
+			"title": {
+				Type:        "string",
+				Description: "The pull request title",
+			},
+			"body": {
+				Type:        "string",
+				Description: "The pull request description in Markdown",
+			},
+		},
+		Required: []string{"title", "body"},
+	}
+
+	fields, err := AskStructured(query, systemPrompt, schema)
+	if err != nil {
+		return "", "", err
+	}
+
+	title := strings.TrimSpace(stringField(fields, "title"))
+	body := strings.TrimSpace(stringField(fields, "body"))
+	if title == "" || body == "" {
+		return "", "", fmt.Errorf("model returned an incomplete pull request description")
+	}
+	return title, body, nil
+}
+
+// findPRTemplate returns the contents of the repository's pull request template, if any
+func findPRTemplate(path string) string {
+	root, err := RepoRoot(path)
+	if err != nil {
+		return ""
+	}
+	for _, candidate := range prTemplatePaths {
+		content, err := os.ReadFile(filepath.Join(root, candidate))
+		if err == nil {
+			return string(content)
+		}
+	}
+	return ""
+}
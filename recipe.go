@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// recipeName matches the names a recipe command may have
+var recipeName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// addRecipeCommands registers the recipes of the config as subcommands of root.
+// Recipes that are invalid or clash with a built-in command are skipped.
+func addRecipeCommands(root *cobra.Command) {
+	config, err := LoadConfig()
+	if err != nil {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: recipes are disabled: %v\n", err)
+		return
+	}
+
+	taken := map[string]bool{"help": true, "completion": true}
+	for _, cmd := range root.Commands() {
+		taken[cmd.Name()] = true
+		for _, alias := range cmd.Aliases {
+			taken[alias] = true
+		}
+	}
+
+	names := make([]string, 0, len(config.Recipes))
+	for name := range config.Recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		recipe := config.Recipes[name]
+		if err := recipe.validate(name); err != nil {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: skipping recipe %q: %v\n", name, err)
+			continue
+		}
+		if taken[name] {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: skipping recipe %q: the name is already used by a command\n", name)
+			continue
+		}
+
+		var aliases []string
+		for _, alias := range recipe.Aliases {
+			if !taken[alias] {
+				aliases = append(aliases, alias)
+				taken[alias] = true
+			}
+		}
+		recipe.Aliases = aliases
+		taken[name] = true
+
+		root.AddCommand(newRecipeCommand(name, recipe))
+	}
+}
+
+// validate checks a recipe before it is registered
+func (r RecipeConfig) validate(name string) error {
+	if !recipeName.MatchString(name) {
+		return fmt.Errorf("the name must be lowercase letters, digits, _ or -")
+	}
+	if strings.TrimSpace(r.System) == "" {
+		return fmt.Errorf("a system prompt is required")
+	}
+	if _, err := template.New(name).Parse(r.System); err != nil {
+		return err
+	}
+	if _, ok := r.outputFields()[r.resultField()]; !ok {
+		if r.Result == "" {
+			return fmt.Errorf("set result to the output field the action applies to")
+		}
+		return fmt.Errorf("result %q is not one of the output fields", r.Result)
+	}
+	switch r.Action {
+	case "", "print", "copy", "run":
+	default:
+		return fmt.Errorf("action must be print, copy or run, not %q", r.Action)
+	}
+	return nil
+}
+
+// outputFields returns the fields of the response, a single result field by default
+func (r RecipeConfig) outputFields() map[string]string {
+	if len(r.Output) == 0 {
+		return map[string]string{"result": "The answer"}
+	}
+	return r.Output
+}
+
+// resultField returns the field the action applies to
+func (r RecipeConfig) resultField() string {
+	if r.Result != "" {
+		return r.Result
+	}
+	if len(r.Output) == 1 {
+		for field := range r.Output {
+			return field
+		}
+	}
+	return "result"
+}
+
+// newRecipeCommand returns the command running a recipe
+func newRecipeCommand(name string, recipe RecipeConfig) *cobra.Command {
+	short := recipe.Short
+	if short == "" {
+		short = fmt.Sprintf("Run the %s recipe", name)
+	}
+
+	cmd := &cobra.Command{
+		Use:     name + " [text]",
+		Aliases: recipe.Aliases,
+		Short:   short,
+		Long: short + `
+
+Defined in the recipes section of ~/.gema/config.yaml. The text is taken from the
+arguments, or from stdin when it is piped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRecipe(cmd, name, recipe, args)
+		},
+	}
+	cmd.Flags().String("model", recipe.Model, "Model to use instead of GENAI_DEFAULT_MODEL")
+	cmd.Flags().String("action", recipe.Action, "What to do with the result: print, copy or run")
+	cmd.Flags().StringToString("var", nil, "Set a variable of the system prompt, e.g. --var dialect=mysql (repeatable)")
+	return cmd
+}
+
+// runRecipe sends the input to the model with the recipe's system prompt and
+// applies the action to the result
+func runRecipe(cmd *cobra.Command, name string, recipe RecipeConfig, args []string) error {
+	input := strings.Join(args, " ")
+	if input == "" {
+		if stdinIsTerminal() {
+			return fmt.Errorf("nothing to do, pass text or pipe it on stdin")
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		input = strings.TrimSpace(string(data))
+	}
+
+	model, _ := cmd.Flags().GetString("model")
+	action, _ := cmd.Flags().GetString("action")
+	vars, _ := cmd.Flags().GetStringToString("var")
+
+	systemPrompt, err := recipeSystemPrompt(name, recipe, input, vars)
+	if err != nil {
+		return err
+	}
+
+	schema := sapiens.Schema{Type: "object", Properties: map[string]sapiens.Schema{}}
+	for field, description := range recipe.outputFields() {
+		schema.Properties[field] = sapiens.Schema{Type: "string", Description: description}
+		schema.Required = append(schema.Required, field)
+	}
+	sort.Strings(schema.Required)
+
+	color.New(color.FgYellow).Fprintf(os.Stderr, "Running the %s recipe...\n", name)
+	fields, err := AskStructuredContext(withModel(context.Background(), model), input, systemPrompt, schema)
+	if err != nil {
+		return err
+	}
+
+	resultField := recipe.resultField()
+	result := strings.TrimSpace(stringField(fields, resultField))
+	for _, field := range optionNames(recipe.outputFields()) {
+		if field != resultField {
+			color.New(color.FgBlue, color.Bold).Printf("%s: ", field)
+			fmt.Println(strings.TrimSpace(stringField(fields, field)))
+		}
+	}
+
+	switch action {
+	case "copy":
+		fmt.Println(result)
+		if err := PutTextOnClipboard(result); err != nil {
+			return fmt.Errorf("failed to copy the result: %w", err)
+		}
+		color.New(color.FgGreen, color.Bold).Println("✓ Copied to the clipboard")
+	case "run":
+		color.New(color.FgYellow, color.Bold).Print("Command: ")
+		fmt.Println(result)
+		var confirm string
+		color.New(color.FgYellow).Print("Run command (y for yes, n for no): ")
+		fmt.Scanln(&confirm)
+		if confirm == "y" {
+			runCommand(result)
+		}
+	case "", "print":
+		fmt.Println(result)
+	default:
+		return fmt.Errorf("unknown action %q, expected print, copy or run", action)
+	}
+	return nil
+}
+
+// recipeSystemPrompt renders the system prompt of a recipe
+func recipeSystemPrompt(name string, recipe RecipeConfig, input string, vars map[string]string) (string, error) {
+	cwd, _ := os.Getwd()
+	data := map[string]string{
+		"input": input,
+		"os":    runtime.GOOS,
+		"arch":  runtime.GOARCH,
+		"shell": filepath.Base(os.Getenv("SHELL")),
+		"cwd":   cwd,
+		"date":  time.Now().Format("2006-01-02"),
+	}
+	for key, value := range recipe.Vars {
+		data[key] = value
+	}
+	for key, value := range vars {
+		data[key] = value
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(recipe.System)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render the system prompt of %s: %w", name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// ConflictHunk is a single conflicted region between merge markers
+type ConflictHunk struct {
+	StartLine   int    // line of the <<<<<<< marker
+	OursLabel   string // label after <<<<<<<
+	TheirsLabel string // label after >>>>>>>
+	Ours        string
+	Base        string // only present with merge.conflictStyle=diff3 or zdiff3
+	Theirs      string
+	Raw         string // the hunk including its markers
+	Resolution  string
+	Resolved    bool
+}
+
+// conflictPart is either plain text or a conflict in a conflicted file
+type conflictPart struct {
+	Text     string
+	Conflict *ConflictHunk
+}
+
+var resolveSystemPrompt = `You resolve git merge conflicts.
+You are given a conflicted hunk with the "ours" side (the branch being merged into or rebased onto),
+the "theirs" side, the common base when available, and the surrounding code.
+Produce the merged code that keeps the intent of both sides. Never leave conflict markers in the
+resolution and do not include the surrounding context, only the code that replaces the hunk.
+Explain briefly which changes you kept from each side and why.`
+
+// ResolveCmd represents the merge conflict resolution command
+var ResolveCmd = &cobra.Command{
+	Use:   "resolve [file...]",
+	Short: "Resolve merge or rebase conflicts with AI assistance",
+	Long: `Finds conflicted files, proposes a resolution for every conflict hunk with an
+explanation, and asks for approval before writing it. Each hunk can be accepted, edited in
+$EDITOR, or skipped. Files whose hunks are all resolved are written and staged.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		root, err := RepoRoot(path)
+		if err != nil {
+			return err
+		}
+
+		files := args
+		if len(files) == 0 {
+			if files, err = ConflictedFiles(root); err != nil {
+				return err
+			}
+		}
+		if len(files) == 0 {
+			color.Green("There are no conflicted files.")
+			return nil
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		for _, file := range files {
+			if err := resolveFile(root, file, reader); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	ResolveCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// ConflictedFiles lists the files with unresolved merge conflicts
+func ConflictedFiles(path string) ([]string, error) {
+	output, err := GitOutput(path, "diff", "--name-only", "-z", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(output, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// ParseConflicts splits a conflicted file into plain text and conflict hunks
+func ParseConflicts(content string) []conflictPart {
+	var parts []conflictPart
+	var text, raw strings.Builder
+	var hunk *ConflictHunk
+	var side *strings.Builder
+	var ours, base, theirs strings.Builder
+
+	lines := strings.SplitAfter(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		switch {
+		case hunk == nil && strings.HasPrefix(trimmed, "<<<<<<<"):
+			if text.Len() > 0 {
+				parts = append(parts, conflictPart{Text: text.String()})
+				text.Reset()
+			}
+			hunk = &ConflictHunk{StartLine: i + 1, OursLabel: strings.TrimSpace(trimmed[7:])}
+			raw.Reset()
+			ours.Reset()
+			base.Reset()
+			theirs.Reset()
+			raw.WriteString(line)
+			side = &ours
+		case hunk != nil && strings.HasPrefix(trimmed, "|||||||"):
+			raw.WriteString(line)
+			side = &base
+		case hunk != nil && trimmed == "=======":
+			raw.WriteString(line)
+			side = &theirs
+		case hunk != nil && strings.HasPrefix(trimmed, ">>>>>>>"):
+			raw.WriteString(line)
+			hunk.TheirsLabel = strings.TrimSpace(trimmed[7:])
+			hunk.Ours, hunk.Base, hunk.Theirs = ours.String(), base.String(), theirs.String()
+			hunk.Raw = raw.String()
+			parts = append(parts, conflictPart{Conflict: hunk})
+			hunk = nil
+		case hunk != nil:
+			raw.WriteString(line)
+			side.WriteString(line)
+		default:
+			text.WriteString(line)
+		}
+	}
+
+	// An unterminated conflict is kept verbatim
+	if hunk != nil {
+		text.WriteString(raw.String())
+	}
+	if text.Len() > 0 {
+		parts = append(parts, conflictPart{Text: text.String()})
+	}
+	return parts
+}
+
+// renderConflicts joins the parts back together, replacing resolved hunks
+func renderConflicts(parts []conflictPart) string {
+	var b strings.Builder
+	for _, part := range parts {
+		switch {
+		case part.Conflict == nil:
+			b.WriteString(part.Text)
+		case part.Conflict.Resolved:
+			b.WriteString(part.Conflict.Resolution)
+		default:
+			b.WriteString(part.Conflict.Raw)
+		}
+	}
+	return b.String()
+}
+
+// ProposeResolution asks the AI for a resolution of a conflict hunk
+func ProposeResolution(file string, hunk *ConflictHunk, before, after string) (string, string, error) {
+	query := fmt.Sprintf("File: %s\n\nCode before the conflict:\n%s\n<<<<<<< ours (%s)\n%s",
+		file, before, hunk.OursLabel, hunk.Ours)
+	if hunk.Base != "" {
+		query += "||||||| base\n" + hunk.Base
+	}
+	query += fmt.Sprintf("=======\n%s>>>>>>> theirs (%s)\n\nCode after the conflict:\n%s",
+		hunk.Theirs, hunk.TheirsLabel, after)
+
+	schema := sapiens.Schema{
+		Type: "object",
+		Properties: map[string]sapiens.Schema{
+			"resolution": {
+				Type:        "string",
+				Description: "The merged code that replaces the conflict hunk",
+			},
+			"explanation": {
+				Type:        "string",
+				Description: "Why the hunk was resolved this way",
+			},
+		},
+		Required: []string{"resolution", "explanation"},
+	}
+
+	fields, err := AskStructured(query, resolveSystemPrompt, schema)
+	if err != nil {
+		return "", "", err
+	}
+
+	resolution := stringField(fields, "resolution")
+	if resolution != "" && !strings.HasSuffix(resolution, "\n") {
+		resolution += "\n"
+	}
+	return resolution, stringField(fields, "explanation"), nil
+}
+
+// resolveFile walks through the conflicts of a file and writes and stages it
+// once every hunk has been resolved. Answers are read from reader.
+func resolveFile(root, file string, reader *bufio.Reader) error {
+	fullPath := filepath.Join(root, file)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	parts := ParseConflicts(string(content))
+
+	var conflicts []int
+	for i, part := range parts {
+		if part.Conflict != nil {
+			conflicts = append(conflicts, i)
+		}
+	}
+	if len(conflicts) == 0 {
+		color.Yellow("%s has no conflict markers, skipping.", file)
+		return nil
+	}
+
+	color.New(color.FgBlue, color.Bold).Printf("\n%s: %d conflict(s)\n", file, len(conflicts))
+
+	for n, index := range conflicts {
+		hunk := parts[index].Conflict
+		before, after := surroundingText(parts, index, 20)
+
+		color.New(color.FgYellow).Printf("\nConflict %d/%d at line %d, asking AI...\n", n+1, len(conflicts), hunk.StartLine)
+		resolution, explanation, err := ProposeResolution(file, hunk, before, after)
+		if err != nil {
+			color.Red("Could not get a resolution: %v", err)
+			resolution = hunk.Raw
+		}
+
+		decided := false
+		for !decided {
+			fmt.Print(color.RedString(hunk.Raw))
+			color.New(color.FgGreen, color.Bold).Println("\nProposed resolution:")
+			fmt.Print(color.GreenString(resolution))
+			if explanation != "" {
+				color.New(color.FgCyan).Printf("\nWhy: %s\n", explanation)
+			}
+
+			fmt.Print(color.CyanString("\nAccept this resolution? (y = accept, e = edit, s = skip): "))
+			choice, err := reader.ReadString('\n')
+			if err != nil && strings.TrimSpace(choice) == "" {
+				fmt.Println()
+				return fmt.Errorf("failed to read your answer, %s was left unchanged: %w", file, err)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(choice)) {
+			case "y", "yes":
+				hunk.Resolution = resolution
+				hunk.Resolved = true
+				decided = true
+			case "e", "edit":
+				edited, err := editInEditor(resolution, filepath.Ext(file))
+				if err != nil {
+					color.Red("Editor failed: %v", err)
+					continue
+				}
+				resolution, explanation = edited, "edited by you"
+			case "s", "skip":
+				color.Yellow("Skipped, the conflict markers are left in place.")
+				decided = true
+			}
+		}
+	}
+
+	if err := os.WriteFile(fullPath, []byte(renderConflicts(parts)), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	for _, index := range conflicts {
+		if !parts[index].Conflict.Resolved || strings.Contains(parts[index].Conflict.Resolution, "<<<<<<<") {
+			color.Yellow("%s still has unresolved conflicts and was not staged.", file)
+			return nil
+		}
+	}
+
+	if _, err := GitOutput(root, "add", "--", file); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", file, err)
+	}
+	color.Green("%s resolved and staged.", file)
+	return nil
+}
+
+// surroundingText returns up to n lines of plain text before and after the part at index
+func surroundingText(parts []conflictPart, index, n int) (string, string) {
+	var before, after string
+	if index > 0 && parts[index-1].Conflict == nil {
+		lines := strings.SplitAfter(parts[index-1].Text, "\n")
+		if len(lines) > n {
+			lines = lines[len(lines)-n:]
+		}
+		before = strings.Join(lines, "")
+	}
+	if index+1 < len(parts) && parts[index+1].Conflict == nil {
+		lines := strings.SplitAfter(parts[index+1].Text, "\n")
+		if len(lines) > n {
+			lines = lines[:n]
+		}
+		after = strings.Join(lines, "")
+	}
+	return before, after
+}
+
+// editInEditor opens text in $EDITOR and returns the edited result
+func editInEditor(text, ext string) (string, error) {
+	tmp, err := os.CreateTemp("", "gema-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	tmp.Close()
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	// EDITOR may contain arguments, e.g. "code --wait"
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], tmp.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(edited), nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// ReviewFinding is a single issue reported by the AI code review
+type ReviewFinding struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Severity   string `json:"severity"`
+	Category   string `json:"category"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+var reviewSystemPrompt = `You are a meticulous senior engineer reviewing a single hunk of a code change.
+Report only real problems introduced or exposed by the changed lines: bugs, security issues,
+performance problems, error handling gaps, maintainability and style problems worth fixing.
+Do not praise the code and do not restate what it does.
+
+Reply with a JSON array and nothing else. Each element has the fields:
+  "file":       the file path shown in the hunk
+  "line":       the new-file line number the finding refers to (shown in the left column)
+  "severity":   one of "high", "medium", "low"
+  "category":   one of "bug", "security", "performance", "error-handling", "maintainability", "style"
+  "message":    a one or two sentence explanation of the problem
+  "suggestion": a concrete fix, as replacement code where possible
+Reply with [] if the hunk has no problems.`
+
+// ReviewCmd represents the code review command
+var ReviewCmd = &cobra.Command{
+	Use:   "review [base]",
+	Short: "Review the working tree or a branch diff with AI",
+	Long: `Sends the diff hunk by hunk to the AI and reports structured findings.
+Without arguments the uncommitted changes (staged and unstaged) are reviewed;
+with a base branch or commit the changes between it and HEAD are reviewed.
+Findings can be printed as a report, as JSON, or as SARIF for CI code scanning.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("path")
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+
+		if format != "text" && format != "json" && format != "sarif" {
+			return fmt.Errorf("unknown format %q, expected text, json or sarif", format)
+		}
+
+		if !IsGitRepo(path) {
+			return fmt.Errorf("the path %s is not a git repository", path)
+		}
+
+		diffArgs := []string{"HEAD"}
+		if len(args) > 0 {
+			diffArgs = []string{args[0] + "...HEAD"}
+		}
+
+		_, diff, err := GetDiff(path, diffArgs...)
+		if err != nil {
+			return err
+		}
+
+		hunks := ParseDiff(diff)
+		if len(hunks) == 0 {
+			color.Yellow("There are no changes to review.")
+			return nil
+		}
+
+		findings, err := ReviewHunks(hunks, func(i int, hunk DiffHunk) {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "\rReviewing hunk %d/%d (%s)...", i+1, len(hunks), hunk.File)
+		})
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if err != nil {
+			return err
+		}
+
+		out := io.Writer(os.Stdout)
+		if output != "" {
+			file, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", output, err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		switch format {
+		case "json":
+			return writeJSON(out, findings)
+		case "sarif":
+			return writeJSON(out, findingsToSARIF(findings))
+		default:
+			printReviewReport(out, findings)
+			return nil
+		}
+	},
+}
+
+func init() {
+	ReviewCmd.Flags().StringP("format", "f", "text", "Output format: text, json or sarif")
+	ReviewCmd.Flags().StringP("output", "o", "", "Write the report to a file instead of stdout")
+	ReviewCmd.Flags().String("path", ".", "Path to the git repository")
+}
+
+// ReviewHunks reviews each hunk separately and returns the combined findings,
+// ordered by file and line. progress, if not nil, is called before each hunk.
+func ReviewHunks(hunks []DiffHunk, progress func(int, DiffHunk)) ([]ReviewFinding, error) {
+	findings := []ReviewFinding{}
+	for i, hunk := range hunks {
+		if progress != nil {
+			progress(i, hunk)
+		}
+
+		var hunkFindings []ReviewFinding
+		if err := AskJSON(hunk.Annotated(), reviewSystemPrompt, &hunkFindings); err != nil {
+			return nil, fmt.Errorf("failed to review %s: %w", hunk.File, err)
+		}
+
+		for _, finding := range hunkFindings {
+			if finding.File == "" {
+				finding.File = hunk.File
+			}
+			finding.Severity = strings.ToLower(finding.Severity)
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+// printReviewReport writes the findings as a compiler-style terminal report
+func printReviewReport(out io.Writer, findings []ReviewFinding) {
+	if len(findings) == 0 {
+		color.New(color.FgGreen, color.Bold).Fprintln(out, "✓ No issues found")
+		return
+	}
+
+	severityColor := map[string]*color.Color{
+		"high":   color.New(color.FgRed, color.Bold),
+		"medium": color.New(color.FgYellow, color.Bold),
+		"low":    color.New(color.FgCyan),
+	}
+
+	for _, finding := range findings {
+		c, ok := severityColor[finding.Severity]
+		if !ok {
+			c = color.New(color.FgWhite)
+		}
+		fmt.Fprintf(out, "%s:%d: %s [%s] %s\n",
+			finding.File, finding.Line, c.Sprint(finding.Severity), finding.Category, finding.Message)
+		if finding.Suggestion != "" {
+			for _, line := range strings.Split(finding.Suggestion, "\n") {
+				fmt.Fprintf(out, "    %s\n", color.GreenString(line))
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d issue(s) found\n", len(findings))
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// findingsToSARIF converts findings to a SARIF 2.1.0 log for CI code scanning upload
+func findingsToSARIF(findings []ReviewFinding) map[string]interface{} {
+	levels := map[string]string{"high": "error", "medium": "warning", "low": "note"}
+
+	rules := []map[string]interface{}{}
+	seenRules := map[string]bool{}
+	results := []map[string]interface{}{}
+
+	for _, finding := range findings {
+		ruleID := finding.Category
+		if ruleID == "" {
+			ruleID = "general"
+		}
+		if !seenRules[ruleID] {
+			seenRules[ruleID] = true
+			rules = append(rules, map[string]interface{}{
+				"id":               ruleID,
+				"shortDescription": map[string]string{"text": ruleID},
+			})
+		}
+
+		level, ok := levels[finding.Severity]
+		if !ok {
+			level = "warning"
+		}
+
+		message := finding.Message
+		if finding.Suggestion != "" {
+			message += "\n\nSuggested fix:\n" + finding.Suggestion
+		}
+
+		line := finding.Line
+		if line < 1 {
+			line = 1
+		}
+
+		results = append(results, map[string]interface{}{
+			"ruleId":  ruleID,
+			"level":   level,
+			"message": map[string]string{"text": message},
+			"locations": []map[string]interface{}{{
+				"physicalLocation": map[string]interface{}{
+					"artifactLocation": map[string]string{"uri": finding.File},
+					"region":           map[string]int{"startLine": line},
+				},
+			}},
+		})
+	}
+
+	return map[string]interface{}{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "gema-review",
+					"informationUri": "https://github.com/4nkitd/gemini-cli",
+					"rules":          rules,
+				},
+			},
+			"results": results,
+		}},
+	}
+}
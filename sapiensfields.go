@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/4nkitd/sapiens"
+)
+
+// Tool parameters, array items and token usage are newer fields of the sapiens
+// types. They are set and read by name so that gema builds against releases
+// without them, which then get tools without parameters and report no usage.
+
+// setSapiensField sets the named field of the struct ptr points to, if it has
+// one that value fits. A pointer value also fits a field of its element type.
+func setSapiensField(ptr interface{}, name string, value interface{}) {
+	field := reflect.ValueOf(ptr).Elem().FieldByName(name)
+	v := reflect.ValueOf(value)
+	if !field.IsValid() || !field.CanSet() || !v.IsValid() {
+		return
+	}
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Type().AssignableTo(field.Type()):
+		field.Set(v.Elem())
+	}
+}
+
+// setSchemaItems sets the element schema of an array schema
+func setSchemaItems(schema *sapiens.Schema, items *sapiens.Schema) {
+	setSapiensField(schema, "Items", items)
+}
+
+// schemaItems returns the element schema of an array schema, or nil
+func schemaItems(schema sapiens.Schema) *sapiens.Schema {
+	field := reflect.ValueOf(schema).FieldByName("Items")
+	if !field.IsValid() {
+		return nil
+	}
+	if field.Kind() == reflect.Ptr {
+		items, _ := field.Interface().(*sapiens.Schema)
+		return items
+	}
+	if items, ok := field.Interface().(sapiens.Schema); ok {
+		return &items
+	}
+	return nil
+}
+
+// setToolInputSchema sets the schema of the arguments of a tool
+func setToolInputSchema(tool *sapiens.Tool, schema *sapiens.Schema) {
+	setSapiensField(tool, "InputSchema", schema)
+}
+
+// responseUsage returns the token usage the provider reported for a response
+func responseUsage(response *sapiens.Response) TokenUsage {
+	usage := reflect.ValueOf(response).Elem().FieldByName("Usage")
+	if usage.Kind() == reflect.Ptr && !usage.IsNil() {
+		usage = usage.Elem()
+	}
+	if usage.Kind() != reflect.Struct {
+		return TokenUsage{}
+	}
+
+	count := func(names ...string) int {
+		for _, name := range names {
+			if field := usage.FieldByName(name); field.IsValid() && field.CanInt() {
+				return int(field.Int())
+			}
+		}
+		return 0
+	}
+	return TokenUsage{
+		PromptTokens:     count("PromptTokens", "InputTokens"),
+		CompletionTokens: count("CompletionTokens", "OutputTokens"),
+		TotalTokens:      count("TotalTokens"),
+		CachedTokens:     count("CachedTokens", "CachedContentTokens", "CachedPromptTokens"),
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// CommitGroup is a set of hunks that belong in one commit
+type CommitGroup struct {
+	Message string `json:"message"`
+	Hunks   []int  `json:"hunks"`
+}
+
+var splitSystemPrompt = `You split a messy working tree into small, logically separate commits.
+You are given numbered diff hunks. Group the hunks so that each group is one coherent change
+(a feature, a fix, a refactor, formatting, docs...) that could be reviewed on its own, and
+order the groups so that each commit builds on the previous ones. Every hunk number must
+appear in exactly one group.
+
+Reply with a JSON array and nothing else. Each element has the fields:
+  "message": the commit message for the group, in present tense and less than 50 words
+  "hunks":   the array of hunk numbers in the group`
+
+// SplitCommits asks the AI to group the unstaged hunks into separate commits
+func SplitCommits(hunks []DiffHunk, systemPrompt string) ([]CommitGroup, error) {
+	var query strings.Builder
+	if systemPrompt != "" {
+		fmt.Fprintf(&query, "Commit message instructions: %s\n\n", systemPrompt)
+	}
+
+	// Share the prompt budget between hunks so large trees still fit
+	perHunk := 60000 / len(hunks)
+	for i, hunk := range hunks {
+		fmt.Fprintf(&query, "### Hunk %d\n%s\n", i+1, limitDiffSize(hunk.Annotated(), perHunk))
+	}
+
+	var groups []CommitGroup
+	if err := AskJSON(query.String(), splitSystemPrompt, &groups); err != nil {
+		return nil, err
+	}
+
+	// Drop unknown and duplicate hunk numbers, and collect unassigned hunks in a final group
+	assigned := make(map[int]bool)
+	var valid []CommitGroup
+	for _, group := range groups {
+		var numbers []int
+		for _, n := range group.Hunks {
+			if n >= 1 && n <= len(hunks) && !assigned[n] {
+				assigned[n] = true
+				numbers = append(numbers, n)
+			}
+		}
+		if len(numbers) > 0 && strings.TrimSpace(group.Message) != "" {
+			sort.Ints(numbers)
+			valid = append(valid, CommitGroup{Message: strings.TrimSpace(group.Message), Hunks: numbers})
+		}
+	}
+
+	var leftover []int
+	for n := 1; n <= len(hunks); n++ {
+		if !assigned[n] {
+			leftover = append(leftover, n)
+		}
+	}
+	if len(leftover) > 0 {
+		valid = append(valid, CommitGroup{Message: "Miscellaneous changes", Hunks: leftover})
+	}
+
+	return valid, nil
+}
+
+// groupPatch builds a patch containing the given (1-based) hunks, with one file
+// header per file, that can be applied with git apply --cached
+func groupPatch(hunks []DiffHunk, numbers []int) string {
+	var b strings.Builder
+	lastHeader := ""
+	for _, n := range numbers {
+		hunk := hunks[n-1]
+		if hunk.FileHeader != lastHeader {
+			b.WriteString(hunk.FileHeader + "\n")
+			lastHeader = hunk.FileHeader
+		}
+		b.WriteString(hunk.Header + "\n")
+		b.WriteString(strings.Join(hunk.Lines, "\n") + "\n")
+	}
+	return b.String()
+}
+
+// runCommitSplit proposes a series of commits for the unstaged changes and
+// applies the accepted ones one by one
+func runCommitSplit(path, systemPrompt string) error {
+	if staged, err := GitOutput(path, "diff", "--cached", "--name-only"); err != nil {
+		return err
+	} else if strings.TrimSpace(staged) != "" {
+		return fmt.Errorf("the index already has staged changes, commit or unstage them before splitting")
+	}
+
+	_, diff, err := GetDiff(path)
+	if err != nil {
+		return err
+	}
+	hunks := ParseDiff(diff)
+	if len(hunks) == 0 {
+		color.Yellow("There are no unstaged changes to split (untracked files are not included).")
+		return nil
+	}
+
+	color.New(color.FgYellow).Printf("Grouping %d hunks into commits...\n", len(hunks))
+	groups, err := SplitCommits(hunks, systemPrompt)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for i, group := range groups {
+		color.New(color.FgBlue, color.Bold).Printf("\nCommit %d/%d\n", i+1, len(groups))
+		color.Green("Commit message: %s", group.Message)
+		for _, n := range group.Hunks {
+			fmt.Printf("  - %s %s\n", hunks[n-1].File, hunks[n-1].Header)
+		}
+
+		fmt.Print(color.CyanString("Apply this commit? (y = yes, e = edit message, n = skip, q = quit): "))
+		choice, _ := reader.ReadString('\n')
+
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "y", "yes":
+		case "e", "edit":
+			fmt.Print(color.CyanString("New commit message: "))
+			message, _ := reader.ReadString('\n')
+			if strings.TrimSpace(message) != "" {
+				group.Message = strings.TrimSpace(message)
+			}
+		case "q", "quit":
+			color.Yellow("Stopped, the remaining changes are left unstaged.")
+			return nil
+		default:
+			color.Yellow("Skipped.")
+			continue
+		}
+
+		if err := ApplyToIndex(path, groupPatch(hunks, group.Hunks)); err != nil {
+			return fmt.Errorf("failed to stage commit %d: %w", i+1, err)
+		}
+		if _, err := GitOutput(path, "commit", "-m", group.Message); err != nil {
+			return fmt.Errorf("error committing changes: %w", err)
+		}
+		color.Green("Committed.")
+	}
+
+	return nil
+}
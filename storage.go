@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
 )
@@ -14,19 +17,38 @@ type Storage struct {
 	db *sql.DB
 }
 
-// NewStorage creates a new storage instance with database in ~/.gema/
+// The database is opened and migrated once per process and shared by every
+// Storage. A failed open is tried again by the next NewStorage.
+var (
+	storageMu sync.Mutex
+	storageDB *sql.DB
+)
+
+// NewStorage returns a storage instance backed by the database in ~/.gema/
 func NewStorage() (*Storage, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	storageMu.Lock()
+	defer storageMu.Unlock()
+
+	if storageDB == nil {
+		db, err := openDatabase()
+		if err != nil {
+			return nil, err
+		}
+		storageDB = db
 	}
+	return &Storage{db: storageDB}, nil
+}
 
-	gemaDir := filepath.Join(homeDir, ".gema")
-	if err := os.MkdirAll(gemaDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create directory %s: %w", gemaDir, err)
+// openDatabase opens ~/.gema/gema.db and creates or migrates its tables
+func openDatabase() (*sql.DB, error) {
+	gemaDir, err := GemaDir()
+	if err != nil {
+		return nil, err
 	}
 
-	dbPath := filepath.Join(gemaDir, "gema.db")
+	// Wait for other gema processes holding a lock instead of failing with
+	// "database is locked", and let readers run alongside a writer
+	dbPath := filepath.Join(gemaDir, "gema.db") + "?_busy_timeout=5000&_journal_mode=WAL"
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -38,30 +60,134 @@ func NewStorage() (*Storage, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Create table if it doesn't exist
+	// Create tables if they don't exist
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS command_history (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		input TEXT NOT NULL,
 		response TEXT NOT NULL,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
-	)
+	);
+	CREATE TABLE IF NOT EXISTS conversations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS conversation_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER NOT NULL REFERENCES conversations(id),
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		command TEXT NOT NULL DEFAULT '',
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS conversation_messages_conversation
+		ON conversation_messages (conversation_id, id);
+	CREATE TABLE IF NOT EXISTS web_spend (
+		day TEXT PRIMARY KEY,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS index_files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		root TEXT NOT NULL,
+		path TEXT NOT NULL,
+		mtime INTEGER NOT NULL,
+		hash TEXT NOT NULL,
+		model TEXT NOT NULL,
+		chunk_size INTEGER NOT NULL,
+		UNIQUE (root, path)
+	);
+	CREATE TABLE IF NOT EXISTS index_chunks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_id INTEGER NOT NULL REFERENCES index_files(id),
+		start_line INTEGER NOT NULL,
+		end_line INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		embedding BLOB NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS index_chunks_file ON index_chunks (file_id);
+	CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		model TEXT NOT NULL,
+		response TEXT NOT NULL,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		hits INTEGER NOT NULL DEFAULT 0
+	);
 	`)
 	if err != nil {
 		db.Close()
-		return nil, fmt.Errorf("failed to create table: %w", err)
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	// Columns added after the tables were first created
+	if err := addMissingColumns(db, "command_history", []string{
+		"cached INTEGER NOT NULL DEFAULT 0",
+		"command TEXT NOT NULL DEFAULT ''",
+		"model TEXT NOT NULL DEFAULT ''",
+		"prompt_tokens INTEGER NOT NULL DEFAULT 0",
+		"completion_tokens INTEGER NOT NULL DEFAULT 0",
+		"total_tokens INTEGER NOT NULL DEFAULT 0",
+		"cached_tokens INTEGER NOT NULL DEFAULT 0",
+		"saved_tokens INTEGER NOT NULL DEFAULT 0",
+		"cost REAL",
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Before saved_tokens, the tokens of cache hits were stored as cached_tokens
+	if _, err := db.Exec(`UPDATE command_history SET saved_tokens = cached_tokens, cached_tokens = 0
+		WHERE cached = 1 AND cached_tokens > 0`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate command_history: %w", err)
+	}
+
+	return db, nil
+}
+
+// addMissingColumns adds the columns, given as "name definition", that a table
+// created by an older version lacks
+func addMissingColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
 	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
 
-	return &Storage{db: db}, nil
+	for _, column := range columns {
+		if existing[strings.Fields(column)[0]] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column); err != nil {
+			return fmt.Errorf("failed to add a column to %s: %w", table, err)
+		}
+	}
+	return nil
 }
 
 // StoreCommand stores a command input and response in the database
-func (s *Storage) StoreCommand(input, response string) error {
+func (s *Storage) StoreCommand(entry HistoryEntry) error {
 	if s.db == nil {
 		return fmt.Errorf("database connection is not initialized")
 	}
 
-	result, err := s.db.Exec("INSERT INTO command_history (input, response) VALUES (?, ?)", input, response)
+	result, err := s.db.Exec(`INSERT INTO command_history (input, response, cached, command, model,
+		prompt_tokens, completion_tokens, total_tokens, cached_tokens, saved_tokens, cost) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Input, entry.Response, entry.Cached, entry.Command, entry.Model, entry.Usage.PromptTokens,
+		entry.Usage.CompletionTokens, entry.Usage.TotalTokens, entry.Usage.CachedTokens, entry.Usage.SavedTokens, entry.Cost)
 	if err != nil {
 		return fmt.Errorf("failed to store command: %w", err)
 	}
@@ -77,16 +203,88 @@ func (s *Storage) StoreCommand(input, response string) error {
 	return nil
 }
 
-// Close closes the database connection
-func (s *Storage) Close() error {
+// HistoryEntry is a stored command and its response
+type HistoryEntry struct {
+	ID        int64      `json:"id"`
+	Input     string     `json:"input"`
+	Response  string     `json:"response"`
+	Timestamp time.Time  `json:"timestamp"`
+	Cached    bool       `json:"cached"`  // the response came from the response cache
+	Command   string     `json:"command"` // e.g. "commit" or "web /api/v1/ask"
+	Model     string     `json:"model"`
+	Usage     TokenUsage `json:"usage"`
+	Cost      *float64   `json:"cost"` // in US dollars, nil when the model has no price
+}
+
+// historyColumns are the columns scanned by scanHistoryEntry
+const historyColumns = `id, input, response, timestamp, cached, command, model,
+	prompt_tokens, completion_tokens, total_tokens, cached_tokens, saved_tokens, cost`
+
+// scanHistoryEntry reads a row of historyColumns
+func scanHistoryEntry(rows *sql.Rows) (HistoryEntry, error) {
+	var entry HistoryEntry
+	err := rows.Scan(&entry.ID, &entry.Input, &entry.Response, &entry.Timestamp, &entry.Cached, &entry.Command, &entry.Model,
+		&entry.Usage.PromptTokens, &entry.Usage.CompletionTokens, &entry.Usage.TotalTokens, &entry.Usage.CachedTokens, &entry.Usage.SavedTokens, &entry.Cost)
+	return entry, err
+}
+
+// ListCommands returns stored commands, newest first
+func (s *Storage) ListCommands(limit, offset int) ([]HistoryEntry, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	rows, err := s.db.Query("SELECT "+historyColumns+" FROM command_history ORDER BY id DESC LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read command: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// SearchCommands returns the stored commands whose input or response contains
+// query, newest first
+func (s *Storage) SearchCommands(query string, limit int) ([]HistoryEntry, error) {
 	if s.db == nil {
-		return nil // No connection to close
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := s.db.Query("SELECT "+historyColumns+` FROM command_history
+		WHERE input LIKE ? ESCAPE '\' OR response LIKE ? ESCAPE '\' ORDER BY id DESC LIMIT ?`, pattern, pattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search commands: %w", err)
 	}
-	return s.db.Close()
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read command: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Close releases the storage. The shared database stays open until the process exits.
+func (s *Storage) Close() error {
+	s.db = nil
+	return nil
 }
 
 // StoreCommandHistory is a facade function that handles database operations internally
-func StoreCommandHistory(input, response string) error {
+func StoreCommandHistory(entry HistoryEntry) error {
 	// Create a new storage instance
 	storage, err := NewStorage()
 	if err != nil {
@@ -100,7 +298,7 @@ func StoreCommandHistory(input, response string) error {
 	}()
 
 	// Store the command
-	if err := storage.StoreCommand(input, response); err != nil {
+	if err := storage.StoreCommand(entry); err != nil {
 		return fmt.Errorf("failed to store command: %w", err)
 	}
 
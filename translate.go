@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// translateProtected matches the spans a translation must keep verbatim:
+// inline code, link targets, URLs, HTML tags and format placeholders such as
+// {name}, {{name}}, ${name}, %s, %1$d and %(name)s
+var translateProtected = regexp.MustCompile("`[^`]+`" +
+	`|\]\([^)]*\)` +
+	`|<https?://[^>]+>|https?://[^\s)>\]]*[^\s)>\].,;:!?]` +
+	`|</?[a-zA-Z][^>]*>` +
+	`|\{\{[^{}]*\}\}|\$\{[^{}]*\}|\{[A-Za-z0-9_.:]*\}` +
+	`|%(\d+\$)?(\([A-Za-z_]+\))?[-+#0]*\d*(\.\d+)?[sdifuxXoeEgGcpqv@%]`)
+
+// translateChunkSize is the approximate number of characters of resource
+// strings sent to the model in one request
+const translateChunkSize = 3000
+
+// TranslateCmd represents the translate command
+var TranslateCmd = &cobra.Command{
+	Use:     "translate [text]",
+	Aliases: []string{"tr"},
+	Short:   "Translate text, documents or i18n resource files",
+	Long: `Translates text given as an argument, on stdin or in a file into the language given with --to.
+Formatting, Markdown, code spans, URLs and placeholders such as {name}, %s and ${var} are kept.
+
+Use --json or --po to translate i18n resource files key by key: JSON files keep their keys and
+layout, PO files get the msgstr of untranslated messages filled in.
+
+A glossary pins the translation of product terms. It is a YAML file mapping terms to their
+translation, optionally grouped by language; an empty translation keeps the term as is:
+
+  de:
+    Workspace: Arbeitsbereich
+    Gema: ""
+
+By default ~/.gema/glossary.yaml is used if it exists.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		from, _ := cmd.Flags().GetString("from")
+		file, _ := cmd.Flags().GetString("file")
+		jsonFile, _ := cmd.Flags().GetString("json")
+		poFile, _ := cmd.Flags().GetString("po")
+		glossaryFile, _ := cmd.Flags().GetString("glossary")
+		output, _ := cmd.Flags().GetString("output")
+
+		sources, source := 0, ""
+		for _, path := range []string{file, jsonFile, poFile} {
+			if path != "" {
+				sources++
+				source = path
+			}
+		}
+		if sources > 1 || (sources == 1 && len(args) > 0) {
+			return fmt.Errorf("give either text, --file, --json or --po")
+		}
+
+		glossary, err := loadGlossary(glossaryFile, to)
+		if err != nil {
+			return err
+		}
+		t := translator{to: to, from: from, glossary: glossary}
+
+		var input []byte
+		switch {
+		case len(args) > 0:
+			input = []byte(args[0])
+		case source != "":
+			if input, err = os.ReadFile(source); err != nil {
+				return fmt.Errorf("failed to read %s: %w", source, err)
+			}
+		default:
+			if stdinIsTerminal() {
+				return fmt.Errorf("nothing to translate, pass text, a file or pipe text on stdin")
+			}
+			if input, err = io.ReadAll(os.Stdin); err != nil {
+				return fmt.Errorf("failed to read stdin: %w", err)
+			}
+		}
+
+		progress := func(done, total int) {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "\rTranslating to %s (%d/%d)...", to, done+1, total)
+		}
+
+		var translated string
+		var skipped int
+		switch {
+		case jsonFile != "":
+			translated, skipped, err = t.translateJSONResource(input, progress)
+		case poFile != "":
+			translated, skipped, err = t.translatePOResource(string(input), progress)
+		default:
+			translated, skipped, err = t.translateDocument(string(input), progress)
+		}
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: %d item(s) were left untranslated because the translation altered placeholders\n", skipped)
+		}
+
+		if output == "" {
+			fmt.Print(translated)
+			if !strings.HasSuffix(translated, "\n") {
+				fmt.Println()
+			}
+			return nil
+		}
+		if err := os.WriteFile(output, []byte(translated), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		color.Green("✓ Translation written to %s", output)
+		return nil
+	},
+}
+
+func init() {
+	TranslateCmd.Flags().String("to", "", "Target language, e.g. de, fr or Brazilian Portuguese")
+	TranslateCmd.Flags().String("from", "", "Source language (detected if omitted)")
+	TranslateCmd.Flags().StringP("file", "f", "", "Translate a text or Markdown file")
+	TranslateCmd.Flags().String("json", "", "Translate the string values of a JSON i18n resource file")
+	TranslateCmd.Flags().String("po", "", "Translate the untranslated messages of a gettext PO file")
+	TranslateCmd.Flags().StringP("glossary", "g", "", "Glossary YAML file pinning term translations (default ~/.gema/glossary.yaml)")
+	TranslateCmd.Flags().StringP("output", "o", "", "Write the translation to a file instead of stdout")
+	TranslateCmd.MarkFlagRequired("to")
+}
+
+// translator holds the settings shared by the translation modes
+type translator struct {
+	to       string
+	from     string
+	glossary map[string]string
+}
+
+// loadGlossary reads the glossary entries for a language. Without an explicit
+// path ~/.gema/glossary.yaml is used when present.
+func loadGlossary(path, lang string) (map[string]string, error) {
+	if path == "" {
+		gemaDir, err := GemaDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(gemaDir, "glossary.yaml")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read glossary: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse glossary %s: %w", path, err)
+	}
+
+	// Entries grouped under the target language win over top-level entries
+	glossary := make(map[string]string)
+	for term, value := range raw {
+		if translation, ok := value.(string); ok {
+			glossary[term] = translation
+		}
+	}
+	if group, ok := raw[lang].(map[string]interface{}); ok {
+		for term, value := range group {
+			if translation, ok := value.(string); ok {
+				glossary[term] = translation
+			}
+		}
+	}
+	return glossary, nil
+}
+
+// systemPrompt builds the translation instructions for a reply shape
+func (t translator) systemPrompt(shape string) string {
+	var b strings.Builder
+	b.WriteString("You are a professional translator. Translate the text into " + t.to)
+	if t.from != "" {
+		b.WriteString(" from " + t.from)
+	}
+	b.WriteString(".\n")
+	b.WriteString("- Keep the formatting: line breaks, Markdown syntax, lists, tables and whitespace.\n")
+	b.WriteString("- Keep every token of the form ⟦n⟧ exactly once and unchanged; they stand for code, URLs and placeholders.\n")
+	b.WriteString("- Translate naturally for native speakers, keeping the tone and register of the source.\n")
+
+	if len(t.glossary) > 0 {
+		terms := make([]string, 0, len(t.glossary))
+		for term := range t.glossary {
+			terms = append(terms, term)
+		}
+		sort.Strings(terms)
+
+		b.WriteString("- Always use this glossary:\n")
+		for _, term := range terms {
+			if t.glossary[term] == "" {
+				fmt.Fprintf(&b, "    %q: do not translate\n", term)
+			} else {
+				fmt.Fprintf(&b, "    %q -> %q\n", term, t.glossary[term])
+			}
+		}
+	}
+
+	b.WriteString("\n" + shape)
+	return b.String()
+}
+
+// translateDocument translates plain text or Markdown paragraph by paragraph,
+// leaving code blocks and front matter untouched
+func (t translator) translateDocument(content string, progress func(done, total int)) (string, int, error) {
+	systemPrompt := t.systemPrompt(`The user sends a JSON array of Markdown paragraphs. Reply with a JSON array
+of the translated paragraphs, in the same order and with exactly the same number of elements,
+and nothing else.`)
+	return rewriteMarkdown(content, false, translateProtected, systemPrompt, progress)
+}
+
+// translateStrings translates a set of resource strings key by key, in chunks.
+// Strings whose placeholders do not survive are left out of the result.
+func (t translator) translateStrings(keys []string, texts map[string]string, progress func(done, total int)) (map[string]string, int, error) {
+	systemPrompt := t.systemPrompt(`The user sends a JSON object mapping resource keys to strings of a user
+interface. Reply with a JSON object with exactly the same keys and the translated strings as
+values, and nothing else. Use the keys only as context; never translate them.`)
+
+	var chunks [][]string
+	size := translateChunkSize
+	for _, key := range keys {
+		if strings.TrimSpace(texts[key]) == "" {
+			continue
+		}
+		if size+len(texts[key]) > translateChunkSize {
+			chunks = append(chunks, nil)
+			size = 0
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], key)
+		size += len(key) + len(texts[key])
+	}
+
+	translated := make(map[string]string)
+	skipped := 0
+	for n, chunk := range chunks {
+		if progress != nil {
+			progress(n, len(chunks))
+		}
+
+		protected := make(map[string]string, len(chunk))
+		spans := make(map[string][]string, len(chunk))
+		for _, key := range chunk {
+			protected[key], spans[key] = protectSpans(texts[key], translateProtected)
+		}
+
+		query, err := json.Marshal(protected)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var reply map[string]string
+		if err := AskJSON(string(query), systemPrompt, &reply); err != nil {
+			return nil, 0, err
+		}
+
+		for _, key := range chunk {
+			text, ok := reply[key]
+			if !ok {
+				skipped++
+				continue
+			}
+			restored, err := restoreMarkdown(text, spans[key])
+			if err != nil {
+				skipped++
+				continue
+			}
+			translated[key] = restored
+		}
+	}
+	return translated, skipped, nil
+}
+
+// translateJSONResource translates the string values of a JSON resource file,
+// keeping its keys, order and non-string values
+func (t translator) translateJSONResource(data []byte, progress func(done, total int)) (string, int, error) {
+	root, err := parseOrderedJSON(data)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse JSON resource: %w", err)
+	}
+
+	var keys []string
+	leaves := make(map[string]*jsonNode)
+	root.stringLeaves("", &keys, leaves)
+
+	texts := make(map[string]string, len(keys))
+	for _, key := range keys {
+		texts[key] = leaves[key].Value.(string)
+	}
+
+	translated, skipped, err := t.translateStrings(keys, texts, progress)
+	if err != nil {
+		return "", 0, err
+	}
+	for key, text := range translated {
+		leaves[key].Value = text
+	}
+	return string(marshalOrderedJSON(root)), skipped, nil
+}
+
+// translatePOResource fills in the msgstr of the untranslated messages of a PO file
+func (t translator) translatePOResource(content string, progress func(done, total int)) (string, int, error) {
+	entries := parsePO(content)
+
+	var keys []string
+	texts := make(map[string]string)
+	for i, entry := range entries {
+		if !entry.untranslated() {
+			continue
+		}
+		key := fmt.Sprintf("%d", i)
+		keys = append(keys, key)
+		texts[key] = entry.MsgID
+		if entry.MsgIDPlural != "" {
+			keys = append(keys, key+"_plural")
+			texts[key+"_plural"] = entry.MsgIDPlural
+		}
+	}
+
+	translated, skipped, err := t.translateStrings(keys, texts, progress)
+	if err != nil {
+		return "", 0, err
+	}
+
+	for i, entry := range entries {
+		key := fmt.Sprintf("%d", i)
+		singular, ok := translated[key]
+		if !ok {
+			continue
+		}
+		plural := singular
+		if entry.MsgIDPlural != "" {
+			if plural, ok = translated[key+"_plural"]; !ok {
+				continue
+			}
+		}
+		entry.setTranslation(singular, plural)
+	}
+	return formatPO(entries), skipped, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslateProtectedPlaceholders(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"50% savings", nil},
+		{"20% of users", nil},
+		{"10% each", nil},
+		{"Hello %s", []string{"%s"}},
+		{"%1$d files", []string{"%1$d"}},
+		{"Hi %(name)s!", []string{"%(name)s"}},
+		{"100%%", []string{"%%"}},
+		{"%-5.2f and %+d", []string{"%-5.2f", "%+d"}},
+		{"Hello {name} and ${user}", []string{"{name}", "${user}"}},
+	}
+	for _, tt := range tests {
+		if got := translateProtected.FindAllString(tt.text, -1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("placeholders in %q = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
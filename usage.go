@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+)
+
+// UsageCmd reports the tokens and cost of the model calls in the history
+var UsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report token usage and cost by day, week or month",
+	Long: `Report token usage and cost by day, week or month.
+
+Every model call is stored in the history of ~/.gema/gema.db with the subcommand
+that made it, the model and the tokens it used. The cost is computed when the
+call is made from the prices section of ~/.gema/config.yaml, in US dollars per
+million tokens; calls to models without a price are counted but not priced.
+Cached tokens are prompt tokens the provider read from its own cache, charged at
+the cached price of the model. Saved tokens were answered from the response cache
+and cost nothing.`,
+	Example: `  ai usage
+  ai usage --period month --by command --command commit
+  ai usage --period week --since 2026-01-01 --format csv > usage.csv`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		period, _ := cmd.Flags().GetString("period")
+		by, _ := cmd.Flags().GetStringSlice("by")
+		since, _ := cmd.Flags().GetString("since")
+		command, _ := cmd.Flags().GetString("command")
+		format, _ := cmd.Flags().GetString("format")
+
+		if _, ok := usagePeriods[period]; !ok {
+			return fmt.Errorf("unknown period %q, expected day, week or month", period)
+		}
+		byCommand, byModel := false, false
+		for _, dimension := range by {
+			switch dimension {
+			case "command":
+				byCommand = true
+			case "model":
+				byModel = true
+			default:
+				return fmt.Errorf("unknown grouping %q, expected command or model", dimension)
+			}
+		}
+		if format != "table" && format != "csv" {
+			return fmt.Errorf("unknown format %q, expected table or csv", format)
+		}
+		var from time.Time
+		if since != "" {
+			var err error
+			if from, err = time.ParseInLocation("2006-01-02", since, time.Local); err != nil {
+				return fmt.Errorf("--since must be a date such as 2026-01-31")
+			}
+		}
+
+		storage, err := NewStorage()
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+
+		entries, err := storage.UsageEntries()
+		if err != nil {
+			return err
+		}
+
+		var filtered []HistoryEntry
+		for _, entry := range entries {
+			if entry.Timestamp.Before(from) || (command != "" && entry.Command != command) {
+				continue
+			}
+			if !byCommand {
+				entry.Command = ""
+			}
+			if !byModel {
+				entry.Model = ""
+			}
+			filtered = append(filtered, entry)
+		}
+		rows := summarizeUsage(filtered, period)
+
+		if format == "csv" {
+			return writeUsageCSV(rows, period, byCommand, byModel)
+		}
+		if len(rows) == 0 {
+			fmt.Println("No model calls recorded")
+			return nil
+		}
+		printUsageTable(rows, period, byCommand, byModel)
+		return nil
+	},
+}
+
+func init() {
+	UsageCmd.Flags().String("period", "day", "Group the calls by day, week (starting on Monday) or month")
+	UsageCmd.Flags().StringSlice("by", []string{"command", "model"}, "Also group by command, model or both")
+	UsageCmd.Flags().String("since", "", "Only count the calls made on or after a date, e.g. 2026-01-31")
+	UsageCmd.Flags().String("command", "", "Only count the calls of a subcommand, e.g. commit")
+	UsageCmd.Flags().String("format", "table", "Output format: table or csv")
+}
+
+// usagePeriods returns the period a local time falls in, as its first day
+var usagePeriods = map[string]func(time.Time) string{
+	"day": func(t time.Time) string { return t.Format("2006-01-02") },
+	"week": func(t time.Time) string {
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return t.AddDate(0, 0, -daysSinceMonday).Format("2006-01-02")
+	},
+	"month": func(t time.Time) string { return t.Format("2006-01") },
+}
+
+// usageRow sums the calls of a period, command and model
+type usageRow struct {
+	Period, Command, Model string
+	Calls                  int
+	Usage                  TokenUsage
+	Cost                   float64
+	Unpriced               int // calls whose model had no price
+}
+
+// summarizeUsage sums history entries by period, command and model, oldest
+// period first
+func summarizeUsage(entries []HistoryEntry, period string) []usageRow {
+	periodOf := usagePeriods[period]
+	rows := map[[3]string]*usageRow{}
+	for _, entry := range entries {
+		key := [3]string{periodOf(entry.Timestamp.Local()), entry.Command, entry.Model}
+		row, ok := rows[key]
+		if !ok {
+			row = &usageRow{Period: key[0], Command: key[1], Model: key[2]}
+			rows[key] = row
+		}
+		row.Calls++
+		row.Usage.Add(entry.Usage)
+		if entry.Cost != nil {
+			row.Cost += *entry.Cost
+		} else {
+			row.Unpriced++
+		}
+	}
+
+	summary := make([]usageRow, 0, len(rows))
+	for _, row := range rows {
+		summary = append(summary, *row)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.Period != b.Period {
+			return a.Period < b.Period
+		}
+		if a.Command != b.Command {
+			return a.Command < b.Command
+		}
+		return a.Model < b.Model
+	})
+	return summary
+}
+
+// usageHeader returns the column names of a report
+func usageHeader(period string, byCommand, byModel bool) []string {
+	header := []string{period}
+	if byCommand {
+		header = append(header, "command")
+	}
+	if byModel {
+		header = append(header, "model")
+	}
+	return append(header, "calls", "prompt_tokens", "completion_tokens", "cached_tokens", "saved_tokens", "total_tokens", "cost_usd")
+}
+
+// fields returns the values of a row in the order of usageHeader
+func (r usageRow) fields(byCommand, byModel bool, cost string) []string {
+	fields := []string{r.Period}
+	if byCommand {
+		fields = append(fields, r.Command)
+	}
+	if byModel {
+		fields = append(fields, r.Model)
+	}
+	return append(fields, strconv.Itoa(r.Calls), strconv.Itoa(r.Usage.PromptTokens), strconv.Itoa(r.Usage.CompletionTokens),
+		strconv.Itoa(r.Usage.CachedTokens), strconv.Itoa(r.Usage.SavedTokens), strconv.Itoa(r.Usage.TotalTokens), cost)
+}
+
+// writeUsageCSV writes a report as CSV on stdout. The cost is empty when no
+// call of the row was priced.
+func writeUsageCSV(rows []usageRow, period string, byCommand, byModel bool) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(usageHeader(period, byCommand, byModel))
+	for _, row := range rows {
+		cost := ""
+		if row.Unpriced < row.Calls {
+			cost = strconv.FormatFloat(row.Cost, 'f', 6, 64)
+		}
+		w.Write(row.fields(byCommand, byModel, cost))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// printUsageTable prints a report with a total. Costs marked with * leave out
+// calls to models without a price.
+func printUsageTable(rows []usageRow, period string, byCommand, byModel bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := usageHeader(period, byCommand, byModel)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+
+	total := usageRow{Period: "total"}
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row.fields(byCommand, byModel, formatUsageCost(row)), "\t"))
+		total.Calls += row.Calls
+		total.Usage.Add(row.Usage)
+		total.Cost += row.Cost
+		total.Unpriced += row.Unpriced
+	}
+	fmt.Fprintln(w, strings.Join(total.fields(byCommand, byModel, formatUsageCost(total)), "\t"))
+	w.Flush()
+
+	if total.Unpriced > 0 {
+		color.New(color.FgYellow).Printf("* leaves out the calls to models without a price (%d), add them under prices in ~/.gema/config.yaml\n", total.Unpriced)
+	}
+}
+
+// formatUsageCost formats the cost of a row, marking rows with unpriced calls
+func formatUsageCost(row usageRow) string {
+	switch {
+	case row.Unpriced == row.Calls:
+		return "-"
+	case row.Unpriced > 0:
+		return fmt.Sprintf("$%.4f*", row.Cost)
+	default:
+		return fmt.Sprintf("$%.4f", row.Cost)
+	}
+}
+
+// UsageEntries returns the history entries of the model calls whose usage was
+// recorded, oldest first, without their input and response
+func (s *Storage) UsageEntries() ([]HistoryEntry, error) {
+	rows, err := s.db.Query(`SELECT id, '', '', timestamp, cached, command, model,
+		prompt_tokens, completion_tokens, total_tokens, cached_tokens, saved_tokens, cost
+		FROM command_history WHERE model != '' ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// usageCost prices the usage of a model with the prices of the config, or
+// returns nil when the model has no price
+func usageCost(model string, usage TokenUsage) *float64 {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil
+	}
+	price, ok := config.Prices[model]
+	if !ok {
+		return nil
+	}
+	cost := price.Cost(usage)
+	return &cost
+}
+
+// currentCommand is the subcommand being run, e.g. "commit" or "writer lint"
+var currentCommand string
+
+// setCurrentCommand records the subcommand being run for the usage report
+func setCurrentCommand(cmd *cobra.Command, args []string) {
+	currentCommand = strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+}
+
+type commandKey struct{}
+
+// withCommand returns a context whose model calls are reported as made by command
+func withCommand(ctx context.Context, command string) context.Context {
+	return context.WithValue(ctx, commandKey{}, command)
+}
+
+// commandName returns the command the calls of a context are reported as
+func commandName(ctx context.Context) string {
+	if command, ok := ctx.Value(commandKey{}).(string); ok {
+		return command
+	}
+	return currentCommand
+}
+
+// routeVariablePattern matches the pattern of a route variable, as in {id:[0-9]+}
+var routeVariablePattern = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// webUsage reports the model calls of a web request as made by its route,
+// e.g. "web /api/v1/ask"
+func webUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				path = routeVariablePattern.ReplaceAllString(template, "{$1}")
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(withCommand(r.Context(), "web "+path)))
+	})
+}
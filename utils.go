@@ -9,7 +9,6 @@ import (
 	"log"
 	"os/exec"
 	"runtime"
-	"strings"
 
 	"github.com/kbinani/screenshot"
 )
@@ -85,30 +84,20 @@ func SpeakMessage(message string, stopSpeech chan struct{}) {
 }
 
 func PutTextOnClipboard(data string) error {
-
-	// Use appropriate clipboard command based on OS
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("pbcopy")
-	case "windows":
-		cmd = exec.Command("clip")
-	case "linux":
-		cmd = exec.Command("xclip", "-selection", "c")
-	default:
-		fmt.Println("Clipboard not supported on this platform")
-		return nil
-	}
-
-	cmd.Stdin = strings.NewReader(data)
-
-	if err := cmd.Run(); err != nil {
+	clipboard, err := SystemClipboard()
+	if err != nil {
 		return err
 	}
+	return clipboard.Write(data)
+}
 
-	return nil
-
+// GetTextFromClipboard returns the current text of the clipboard
+func GetTextFromClipboard() (string, error) {
+	clipboard, err := SystemClipboard()
+	if err != nil {
+		return "", err
+	}
+	return clipboard.Read()
 }
 
 func RecordAudio(seconds int) ([]byte, error) {
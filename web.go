@@ -1,13 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
@@ -19,80 +24,218 @@ var WebCmd = &cobra.Command{
 	Use:   "web",
 	Short: "Start a web server with chat interface and API",
 	Long: `Starts a web server that provides both a web interface and REST API for AI interactions.
-The server runs on port 8080 by default (configurable via PORT environment variable).
+The server listens on 127.0.0.1 and port 8080 by default (configurable via the GENAI_PORT
+environment variable or --listen).
+
+The API requires a bearer token, which is generated on first start, saved in
+~/.gema/web-token and printed with the URL of the web interface. Send it as
+"Authorization: Bearer <token>". Browsers may only call the API from the web interface
+itself or from origins given with --allow-origin.
+
 Available endpoints:
 - Web UI: http://localhost:8080/
-- API: POST to http://localhost:8080/answer with JSON body {"message": "your question", "history": {}}`,
-	Run: executeWebCommand,
+- Health checks: /healthz (liveness) and /readyz (provider and database)
+- API: versioned JSON API under http://localhost:8080/api/v1, described by
+  http://localhost:8080/api/v1/openapi.json
+- OpenAI-compatible API: http://localhost:8080/v1/chat/completions and /v1/models, with
+  the token as API key
+- Legacy API: POST to http://localhost:8080/answer with JSON body {"message": "your question", "history": {}}`,
+	RunE: executeWebCommand,
 }
 
-func executeWebCommand(cmd *cobra.Command, args []string) {
-	port := getPort()
-	r := mux.NewRouter()
+func init() {
+	WebCmd.Flags().String("listen", "", "Address to listen on (default 127.0.0.1 and GENAI_PORT or 8080)")
+	WebCmd.Flags().Bool("tls", false, "Serve HTTPS with a self-signed certificate kept in ~/.gema")
+	WebCmd.Flags().String("cert", "", "TLS certificate file to serve HTTPS with (requires --key)")
+	WebCmd.Flags().String("key", "", "TLS private key file (requires --cert)")
+	WebCmd.Flags().StringSlice("allow-origin", nil, "Extra origins allowed to call the API from a browser, e.g. http://localhost:3000")
+	WebCmd.Flags().Bool("reset-token", false, "Generate a new API token, invalidating the old one")
+	WebCmd.Flags().Bool("no-browser", false, "Do not open the web interface in a browser")
+	WebCmd.Flags().String("log-format", "text", "Access log format on stderr: text or json")
+}
 
-	// Open browser after a short delay to ensure server is running
-	go func() {
-		time.Sleep(500 * time.Millisecond)
-		url := fmt.Sprintf("http://localhost:%d", port)
-		log.Printf("Opening %s in your browser", url)
-		if err := exec.Command("open", url).Run(); err != nil {
-			// Try with xdg-open for Linux
-			if err := exec.Command("xdg-open", url).Run(); err != nil {
-				// Try with start for Windows
-				if err := exec.Command("cmd", "/c", "start", url).Run(); err != nil {
-					log.Printf("Could not open browser: %v", err)
-				}
-			}
+func executeWebCommand(cmd *cobra.Command, args []string) error {
+	listen, _ := cmd.Flags().GetString("listen")
+	useTLS, _ := cmd.Flags().GetBool("tls")
+	certFile, _ := cmd.Flags().GetString("cert")
+	keyFile, _ := cmd.Flags().GetString("key")
+	origins, _ := cmd.Flags().GetStringSlice("allow-origin")
+	resetToken, _ := cmd.Flags().GetBool("reset-token")
+	noBrowser, _ := cmd.Flags().GetBool("no-browser")
+	logFormat, _ := cmd.Flags().GetString("log-format")
+
+	if listen == "" {
+		listen = fmt.Sprintf("127.0.0.1:%d", getPort())
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	if logFormat != "text" && logFormat != "json" {
+		return fmt.Errorf("unknown log format %q, expected text or json", logFormat)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("--cert and --key must be given together")
+	}
+	useTLS = useTLS || certFile != ""
+
+	token, err := loadWebToken(resetToken)
+	if err != nil {
+		return err
+	}
+	if useTLS && certFile == "" {
+		if certFile, keyFile, err = selfSignedCertificate(certificateHosts(host)); err != nil {
+			return err
 		}
-	}()
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	limits := newWebLimits(config.Web, config.Prices)
+	if config.Web.Budget.DailyCost > 0 && !limits.priced {
+		color.New(color.FgYellow).Printf("Warning: web.budget.daily_cost is set but prices has no entry for %q, so only the token budget applies\n",
+			os.Getenv("GENAI_DEFAULT_MODEL"))
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if logFormat == "json" {
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+	health := &webHealth{}
 
-	r.HandleFunc("/answer", answerHandler).Methods("POST")
+	r := mux.NewRouter()
+	r.Use(accessLog(logger), newWebGuard(token, origins).Middleware, webCache, webUsage)
+
+	// Preflights only need the CORS headers set by the guard
+	r.Methods(http.MethodOptions).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.HandleFunc("/healthz", health.healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", health.readyzHandler).Methods("GET")
+	r.HandleFunc("/answer", limits.Limit(answerHandler)).Methods("POST")
+	registerAPIRoutes(r, limits.Limit)
+	registerOpenAIRoutes(r, limits.Limit)
 
 	webFS := getEmbeddedWebFS()
 	r.PathPrefix("/").Handler(http.FileServer(http.FS(webFS)))
 
-	log.Printf("Starting web server on port %d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), r))
-}
-
-func answerHandler(w http.ResponseWriter, r *http.Request) {
+	scheme, browseHost := "http", host
+	if useTLS {
+		scheme = "https"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		browseHost = "localhost"
+	}
+	// The token is passed in the fragment so it is never sent in a request line
+	url := fmt.Sprintf("%s://%s/#token=%s", scheme, net.JoinHostPort(browseHost, port), token)
 
-	var requestBody struct {
-		Message string            `json:"message"`
-		History map[string]string `json:"history"`
+	server := &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
+	// Bind before announcing the URL so a port in use fails straight away
+	listener, err := net.Listen("tcp", listen)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if !isLoopbackHost(host) {
+		color.New(color.FgYellow).Printf("Warning: listening on %s, which is reachable from other machines. Anyone with the token can use your API key.\n", listen)
+	}
+	color.New(color.FgGreen).Printf("Gema is running at %s\n", url)
+	if !noBrowser {
+		go openBrowser(url)
+	}
 
-	var formattedHistory string
-	if len(requestBody.History) > 0 {
-		formattedHistory = "Previous conversation:\n"
-		for question, answer := range requestBody.History {
-			formattedHistory += "Question: " + question + "\nAnswer: " + answer + "\n\n"
+	serveErr := make(chan error, 1)
+	go func() {
+		if useTLS {
+			serveErr <- server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			serveErr <- server.Serve(listener)
 		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
+	stop() // a second signal kills the process
+
+	// Let in-flight answers finish; they cannot run longer than the request timeout
+	health.draining.Store(true)
+	color.New(color.FgYellow).Println("Shutting down, waiting for running requests to finish (press Ctrl+C again to force)")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), limits.timeout+5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down cleanly: %w", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	color.New(color.FgGreen).Println("✓ Server stopped")
+	return nil
+}
 
-	query := fmt.Sprintf("You are an AI assistant. %sNew question: %s",
-		formattedHistory,
-		requestBody.Message)
+// openBrowser opens a URL in the default browser after a short delay to ensure
+// the server is running
+func openBrowser(url string) {
+	time.Sleep(500 * time.Millisecond)
+	if err := exec.Command("open", url).Run(); err != nil {
+		// Try with xdg-open for Linux
+		if err := exec.Command("xdg-open", url).Run(); err != nil {
+			// Try with start for Windows
+			if err := exec.Command("cmd", "/c", "start", url).Run(); err != nil {
+				log.Printf("Could not open browser: %v", err)
+			}
+		}
+	}
+}
 
-	os.Setenv("SKIP_SYS_INFO", "true")
+// isLoopbackHost reports whether a listen host is only reachable from this machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// answerHandler serves the original chat endpoint used by the web UI. New
+// clients should use POST /api/v1/ask.
+func answerHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Message string            `json:"message"`
+		History map[string]string `json:"history"`
+	}
+	if !decodeAPIRequest(w, r, &requestBody) {
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	var history []ChatTurn
+	for question, answer := range requestBody.History {
+		history = append(history,
+			ChatTurn{Role: "user", Content: question},
+			ChatTurn{Role: "assistant", Content: answer})
+	}
 
-	os.Setenv("SKIP_SYS_INFO", "true")
-	ai := AskQuery(query, nil)
-	os.Setenv("SKIP_SYS_INFO", "")
+	ai, err := AskQueryContext(r.Context(), formatHistoryPrompt(history, requestBody.Message), nil)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
 
-	response := `{"message": " ` + ai.Response + `"}`
-	w.Write([]byte(response))
+	writeAPIJSON(w, http.StatusOK, struct {
+		Message string `json:"message"`
+		Command string `json:"command,omitempty"`
+	}{ai.Response, ai.Command})
 }
 
 func getPort() int {
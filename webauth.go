@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// webTokenFile is the file in ~/.gema holding the bearer token of the web server
+const webTokenFile = "web-token"
+
+// webGuard protects the web server: it rejects requests from foreign origins,
+// answers CORS preflights for allowed ones and requires the bearer token on
+// the API. The static UI is served without a token; it reads the token from
+// the URL printed at startup.
+type webGuard struct {
+	token   string
+	origins map[string]bool // extra origins allowed to call the API
+}
+
+// newWebGuard returns the token and origin checks for the web server
+func newWebGuard(token string, allowedOrigins []string) *webGuard {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[strings.TrimRight(origin, "/")] = true
+	}
+	return &webGuard{token: token, origins: origins}
+}
+
+// Middleware applies the checks before the router's handlers. CORS preflights
+// must reach it through a route that matches OPTIONS requests.
+func (g *webGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, r.Host) {
+			if !g.origins[origin] {
+				writeAPIError(w, http.StatusForbidden, errCodeForbidden, "origin "+origin+" is not allowed")
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id, X-Gema-Cache, Age")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Cache-Control, Mcp-Session-Id, Mcp-Protocol-Version")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		if isAPIPath(r.URL.Path) && !g.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gema"`)
+			writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "missing or invalid bearer token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request carries the server's bearer token
+func (g *webGuard) authorized(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(g.token)) == 1
+}
+
+// isAPIPath reports whether a path belongs to the API rather than the static UI
+func isAPIPath(path string) bool {
+	return path == "/answer" || path == "/api" || strings.HasPrefix(path, "/api/") ||
+		path == "/v1" || strings.HasPrefix(path, "/v1/") || path == "/mcp"
+}
+
+// sameOrigin reports whether an Origin header names the host the request was sent to
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, host)
+}
+
+// loadWebToken returns the bearer token of the web server, generating and
+// saving a new one on first start or when reset is set
+func loadWebToken(reset bool) (string, error) {
+	gemaDir, err := GemaDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(gemaDir, webTokenFile)
+
+	if !reset {
+		data, err := os.ReadFile(path)
+		if err == nil && strings.TrimSpace(string(data)) != "" {
+			return strings.TrimSpace(string(data)), nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return token, nil
+}
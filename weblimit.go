@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults for the limits of the web server
+const (
+	defaultWebRateLimit = 20 // requests per minute per client
+	defaultWebBurst     = 5
+	defaultWebWorkers   = 4
+	defaultWebQueue     = 16
+	defaultWebTimeout   = 2 * time.Minute
+)
+
+// maxIdleBuckets is the number of client buckets kept before full ones are dropped
+const maxIdleBuckets = 1024
+
+// webLimits throttles the requests that call the model: a token bucket per
+// client, a bounded number of workers with a bounded queue, a timeout per
+// request and a daily budget
+type webLimits struct {
+	rate    float64 // bucket refill per second
+	burst   float64
+	timeout time.Duration
+	budget  BudgetConfig
+	price   ModelPrice
+	priced  bool // whether the model has a price, so the cost budget applies
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	slots   chan struct{} // one per running request
+	pending chan struct{} // one per running or queued request
+}
+
+// tokenBucket holds the requests a client may still make
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newWebLimits applies the defaults to the web config
+func newWebLimits(config WebConfig, prices map[string]ModelPrice) *webLimits {
+	if config.RateLimit <= 0 {
+		config.RateLimit = defaultWebRateLimit
+	}
+	if config.Burst <= 0 {
+		config.Burst = defaultWebBurst
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultWebWorkers
+	}
+	if config.Queue < 0 {
+		config.Queue = 0
+	} else if config.Queue == 0 {
+		config.Queue = defaultWebQueue
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultWebTimeout
+	}
+
+	price, priced := prices[os.Getenv("GENAI_DEFAULT_MODEL")]
+	return &webLimits{
+		rate:    config.RateLimit / 60,
+		burst:   float64(config.Burst),
+		timeout: config.Timeout,
+		budget:  config.Budget,
+		price:   price,
+		priced:  priced,
+		buckets: make(map[string]*tokenBucket),
+		slots:   make(chan struct{}, config.Workers),
+		pending: make(chan struct{}, config.Workers+config.Queue),
+	}
+}
+
+// Limit wraps a handler that calls the model. Requests over the rate limit, the
+// queue depth or the daily budget get a 429; the handler's context is cancelled
+// on timeout or when the client disconnects.
+func (l *webLimits) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.allow(clientAddress(r), time.Now()); !ok {
+			retryAfter(w, wait)
+			writeAPIError(w, http.StatusTooManyRequests, errCodeRateLimited,
+				fmt.Sprintf("rate limit exceeded, retry in %s", wait.Round(time.Second)))
+			return
+		}
+
+		if err := l.checkBudget(); err != nil {
+			retryAfter(w, untilTomorrow(time.Now()))
+			writeAPIError(w, http.StatusTooManyRequests, errCodeBudgetExceeded, err.Error())
+			return
+		}
+
+		select {
+		case l.pending <- struct{}{}:
+			defer func() { <-l.pending }()
+		default:
+			retryAfter(w, 5*time.Second)
+			writeAPIError(w, http.StatusTooManyRequests, errCodeServerBusy, "too many requests are queued, retry later")
+			return
+		}
+
+		select {
+		case l.slots <- struct{}{}:
+			defer func() { <-l.slots }()
+		case <-r.Context().Done():
+			return // the client went away while queued
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), l.timeout)
+		defer cancel()
+		ctx, meter := withUsageMeter(ctx)
+
+		next(w, r.WithContext(ctx))
+
+		if err := l.charge(meter.Usage()); err != nil {
+			log.Printf("Error recording spend: %v", err)
+		}
+	}
+}
+
+// allow takes a token from the client's bucket. If it is empty it returns the
+// time until the next token.
+func (l *webLimits) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.pruneBuckets(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// pruneBuckets drops the buckets that have refilled, as they are the same as new ones
+func (l *webLimits) pruneBuckets(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// checkBudget fails once today's spend reaches the daily budget
+func (l *webLimits) checkBudget() error {
+	if l.budget.DailyTokens <= 0 && (l.budget.DailyCost <= 0 || !l.priced) {
+		return nil
+	}
+
+	storage, err := NewStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	tokens, cost, err := storage.WebSpend(today())
+	if err != nil {
+		return err
+	}
+	if l.budget.DailyTokens > 0 && tokens >= l.budget.DailyTokens {
+		return fmt.Errorf("daily token budget of %d tokens is used up", l.budget.DailyTokens)
+	}
+	if l.budget.DailyCost > 0 && l.priced && cost >= l.budget.DailyCost {
+		return fmt.Errorf("daily budget of $%.2f is used up", l.budget.DailyCost)
+	}
+	return nil
+}
+
+// charge adds the usage of a request to today's spend
+func (l *webLimits) charge(usage TokenUsage) error {
+	if usage.TotalTokens == 0 && usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return nil
+	}
+	tokens := usage.TotalTokens
+	if tokens == 0 {
+		tokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	storage, err := NewStorage()
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	return storage.AddWebSpend(today(), tokens, l.price.Cost(usage))
+}
+
+// clientAddress identifies the client of a request by its IP address
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfter sets the Retry-After header, rounded up to whole seconds
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// today returns the current local date, which keys the daily spend
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// untilTomorrow returns the time left until local midnight
+func untilTomorrow(now time.Time) time.Duration {
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location()).Sub(now)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HealthResponse is the body of GET /healthz and GET /readyz
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// webHealth answers the liveness and readiness probes of the web server
+type webHealth struct {
+	draining atomic.Bool // set on shutdown so load balancers stop sending requests
+}
+
+// healthzHandler reports that the process is up
+func (h *webHealth) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyzHandler reports whether requests can be served: the provider must be
+// configured and the database reachable
+func (h *webHealth) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"provider": "ok", "database": "ok"}
+	ready := true
+
+	if _, err := newAgent(); err != nil {
+		checks["provider"] = err.Error()
+		ready = false
+	}
+	if storage, err := NewStorage(); err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		if err := storage.db.PingContext(r.Context()); err != nil {
+			checks["database"] = err.Error()
+			ready = false
+		}
+		storage.Close()
+	}
+	if h.draining.Load() {
+		checks["server"] = "shutting down"
+		ready = false
+	}
+
+	if !ready {
+		writeAPIJSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Checks: checks})
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, HealthResponse{Status: "ready", Checks: checks})
+}
+
+// statusRecorder remembers the status and size of a response for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// accessLog logs one structured line per request
+func accessLog(logger *slog.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			logger.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", recorder.bytes,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"client", clientAddress(r),
+				"user_agent", r.UserAgent(),
+			)
+		})
+	}
+}
@@ -1,71 +1,443 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/4nkitd/sapiens"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
+// WriterOptions describe how the writer should revise a text
+type WriterOptions struct {
+	Tone         string `yaml:"tone"`
+	Format       string `yaml:"format"`
+	Audience     string `yaml:"audience"`
+	Lang         string `yaml:"lang"`
+	Length       int    `yaml:"length"`       // approximate length in words, 0 keeps the input length
+	Instructions string `yaml:"instructions"` // extra free-form instructions
+}
+
+// writerTones maps the supported tones to their instructions
+var writerTones = map[string]string{
+	"professional": "Make it sound professional: clear, concise, grammatically correct and appropriate for business communication. Avoid slang, colloquialisms and overly informal language.",
+	"friendly":     "Make it warm, approachable and conversational while staying clear and polite.",
+	"formal":       "Make it formal and courteous, with complete sentences and no contractions or colloquialisms.",
+	"concise":      "Make it as short and direct as possible without losing information. Remove filler and hedging.",
+	"assertive":    "Make it confident and direct. State requests and positions clearly, without hedging or excessive apologies.",
+}
+
+// writerFormats maps the supported output formats to their instructions
+var writerFormats = map[string]string{
+	"email":      "Format the output as an email with a subject line, a greeting, the body and a closing.",
+	"slack":      "Format the output as a Slack message: short paragraphs, no subject line or signature, Slack markdown (*bold*, bullet lists) where it helps.",
+	"tweet":      "Format the output as a single tweet of at most 280 characters, without hashtags unless the input has them.",
+	"pr-comment": "Format the output as a code review comment on a pull request: specific, constructive, Markdown with code spans for identifiers.",
+	"bullet":     "Format the output as a bulleted list with one idea per bullet.",
+}
+
+// writerAudiences maps the supported audiences to their instructions
+var writerAudiences = map[string]string{
+	"exec":     "The readers are executives: lead with the outcome and business impact, avoid technical detail and jargon.",
+	"engineer": "The readers are engineers: be precise and technical, keep exact names, numbers and error messages.",
+	"customer": "The readers are customers: be empathetic and plain-spoken, avoid internal jargon and explain next steps.",
+}
+
+var (
+	lengthTag = regexp.MustCompile(`\[length=(\d+)\]`)
+	typeTag   = regexp.MustCompile(`\[type=([a-z-]+)\]`)
+)
+
 // WriterCmd represents the writer command
 var WriterCmd = &cobra.Command{
 	Use:     "writer [text]",
 	Aliases: []string{"revise", "edit", "improve", "refine", "w"},
-	Short:   "Revises text to be more professional using Gemini AI",
-	Long: `A command that uses the Gemini API to revise input text and make it more professional.
-It maintains the original length unless instructed otherwise with [length=X] or [type=email] tags.`,
-	Args: cobra.ExactArgs(1),
+	Short:   "Revises text with a chosen tone, format and audience using Gemini AI",
+	Long: `A command that uses the Gemini API to revise input text. By default it makes the text
+more professional and keeps its original length.
+
+Use --tone, --format, --audience, --lang and --length to steer the revision, or --preset to
+apply a named preset from the writer.presets section of ~/.gema/config.yaml. The inline
+[length=X] and [type=email] tags are still understood.
+
+Use --file to revise a document. Code blocks, inline code, links, front matter and
+headings are kept as they are and only prose paragraphs are rewritten; add --in-place
+to overwrite the file (a backup is kept next to it).
+
+Use --clipboard to revise the text on the clipboard and replace it with the result, e.g.
+from a desktop hotkey.
+
+Use --diff to see a word-level diff of what changed, or --accept-partial to accept or
+reject each change.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("file")
+		useClipboard, _ := cmd.Flags().GetBool("clipboard")
+		if file != "" || useClipboard {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := writerOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		if file, _ := cmd.Flags().GetString("file"); file != "" {
+			return reviseFile(cmd, file, opts)
+		}
+
+		if useClipboard, _ := cmd.Flags().GetBool("clipboard"); useClipboard {
+			return reviseClipboard(opts)
+		}
+
 		selectedText := args[0]
 
 		// Indicate processing
 		processingMsg := color.New(color.FgYellow).PrintFunc()
 		processingMsg("Processing your text with Gemini AI...\n")
 
-		extractedText, err := extractGeminiText(selectedText)
+		extractedText, err := extractGeminiText(selectedText, opts)
 		if err != nil {
 			errorMsg := color.New(color.FgRed, color.Bold).PrintFunc()
 			errorMsg("Error: " + err.Error())
 			return err
 		}
 
+		showDiff, _ := cmd.Flags().GetBool("diff")
+		acceptPartial, _ := cmd.Flags().GetBool("accept-partial")
+		if showDiff || acceptPartial {
+			original, _ := applyInlineTags(selectedText, opts)
+			extractedText = reviewRevision(original, extractedText, acceptPartial)
+		}
+
 		// Print success indicator and the resulting text
 		successMsg := color.New(color.FgGreen, color.Bold).PrintFunc()
-		successMsg("✓ Professional revision complete:\n\n")
+		successMsg("✓ Revision complete:\n\n")
 		fmt.Println(extractedText)
 		return nil
 	},
 }
 
-func extractGeminiText(selectedText string) (string, error) {
-	// Rest of the function remains the same...
-	systemPrompt := fmt.Sprintf(`
-	Please revise the following text to be more professional. Maintain the original length as closely as possible, unless a length constraint or output type is specified.
+func init() {
+	WriterCmd.Flags().String("tone", "", "Tone of the revision: "+strings.Join(optionNames(writerTones), "|"))
+	WriterCmd.Flags().String("format", "", "Output format: "+strings.Join(optionNames(writerFormats), "|"))
+	WriterCmd.Flags().String("audience", "", "Intended readers: "+strings.Join(optionNames(writerAudiences), "|"))
+	WriterCmd.Flags().String("lang", "", "Language of the output (defaults to the language of the input)")
+	WriterCmd.Flags().Int("length", 0, "Approximate length of the output in words")
+	WriterCmd.Flags().String("preset", "", "Named preset from the writer.presets section of ~/.gema/config.yaml")
+	WriterCmd.Flags().Bool("diff", false, "Show a word-level diff of the changes with a rationale for the major ones")
+	WriterCmd.Flags().Bool("accept-partial", false, "Accept or reject each change interactively")
+	WriterCmd.Flags().StringP("file", "f", "", "Revise the prose of a (Markdown) file instead of an argument")
+	WriterCmd.Flags().BoolP("in-place", "i", false, "With --file, overwrite the file instead of printing the result")
+	WriterCmd.Flags().BoolP("clipboard", "c", false, "Revise the text on the clipboard and put the result back on it")
+	WriterCmd.Flags().String("backup-suffix", ".bak", "With --in-place, suffix of the backup copy of the original file")
+}
 
-	**Input Text:**
-	%s
+// reviseFile rewrites the prose of a document and prints it or, with
+// --in-place, replaces the file after saving a backup
+func reviseFile(cmd *cobra.Command, file string, opts WriterOptions) error {
+	inPlace, _ := cmd.Flags().GetBool("in-place")
+	backupSuffix, _ := cmd.Flags().GetString("backup-suffix")
 
-	**Instructions:**
+	if opts.Format != "" || opts.Length > 0 {
+		return fmt.Errorf("--format and --length cannot be used with --file")
+	}
+	if diff, _ := cmd.Flags().GetBool("diff"); diff {
+		return fmt.Errorf("--diff cannot be used with --file")
+	}
+	if acceptPartial, _ := cmd.Flags().GetBool("accept-partial"); acceptPartial {
+		return fmt.Errorf("--accept-partial cannot be used with --file")
+	}
 
-	-   **Default Behavior:** If no explicit instructions are provided, maintain the original length of the input text (within a reasonable tolerance, a few words longer or shorter is acceptable) while making it sound more professional.
-	-   **Length Constraint:** If [length=X] is present (where X is a number), adjust the output to be approximately X words long.
-	-   **Output Type:** If [type=email] is present, format the output as a professional email, including a subject line, a greeting, and a closing.
-	-   **Professionalism:**  Focus on clarity, concise wording, proper grammar, and a tone that is appropriate for business or formal communication. Avoid slang, colloquialisms, and overly informal language.
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
 
-	**Example Usage:**
+	revised, skipped, err := reviseMarkdown(string(content), opts, func(done, total int) {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "\rRevising %s (%d/%d)...", file, done+1, total)
+	})
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: %d paragraph(s) were left unchanged because the revision altered code or links\n", skipped)
+	}
 
-	-   **Basic:** Input Text: "Hey, wanna chat later?"  (Output would be something like: "I was wondering if you would be available to connect later?")
-	-   **With Length Constraint:** Input Text: "This is a really long message that talks about a lot of stuff, so you should probably read it carefully. Blah blah blah blah blah." [length=50]
-	(Output would be a refined, professional version of the input, limited to around 50 words.)
-	-   **Email Format:** Input Text: "Hi, can we talk about this important thing? Thx." [type=email] (Output would be a complete email with a subject, greeting, closing)
+	if !inPlace {
+		fmt.Print(revised)
+		return nil
+	}
 
-	Please provide the improved text according to the given instructions.
-	`, selectedText)
+	backup := file + backupSuffix
+	if err := os.WriteFile(backup, content, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write backup %s: %w", backup, err)
+	}
+	if err := os.WriteFile(file, []byte(revised), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	color.Green("✓ %s revised, the original was saved to %s", file, backup)
+	return nil
+}
+
+// reviseClipboard revises the clipboard text in place
+func reviseClipboard(opts WriterOptions) error {
+	selectedText, err := GetTextFromClipboard()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(selectedText) == "" {
+		return fmt.Errorf("the clipboard does not contain any text")
+	}
+
+	revised, err := extractGeminiText(selectedText, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := PutTextOnClipboard(revised); err != nil {
+		return err
+	}
+	color.New(color.FgGreen, color.Bold).Println("✓ Revision copied to the clipboard")
+	return nil
+}
+
+// reviewRevision shows what the revision changed and, in interactive mode, lets
+// the user pick the edits to keep. It returns the final text.
+func reviewRevision(original, revised string, interactive bool) string {
+	segments := diffWords(original, revised)
+	if len(diffEdits(segments)) == 0 {
+		color.Yellow("The revision made no changes.")
+		return revised
+	}
+
+	if err := explainEdits(diffEdits(segments)); err != nil {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: could not explain the changes: %v\n", err)
+	}
+
+	color.New(color.FgBlue, color.Bold).Println("Changes:")
+	fmt.Println(renderDiff(segments))
+	printRationales(segments)
+
+	if interactive {
+		return reviewEdits(segments)
+	}
+	fmt.Println()
+	return revised
+}
 
-	// Use the AskQuery function from gemini.go
-	response := AskQuery(systemPrompt, nil)
+// writerOptionsFromFlags resolves the writer options from the preset and the
+// command line flags, flags taking precedence over the preset
+func writerOptionsFromFlags(cmd *cobra.Command) (WriterOptions, error) {
+	var opts WriterOptions
+
+	if preset, _ := cmd.Flags().GetString("preset"); preset != "" {
+		presetOpts, err := loadWriterPreset(preset)
+		if err != nil {
+			return opts, err
+		}
+		opts = presetOpts
+	}
+
+	for _, flag := range []struct {
+		name  string
+		value *string
+	}{
+		{"tone", &opts.Tone},
+		{"format", &opts.Format},
+		{"audience", &opts.Audience},
+		{"lang", &opts.Lang},
+	} {
+		if cmd.Flags().Changed(flag.name) {
+			*flag.value, _ = cmd.Flags().GetString(flag.name)
+		}
+	}
+	if cmd.Flags().Changed("length") {
+		opts.Length, _ = cmd.Flags().GetInt("length")
+	}
+
+	return opts, opts.validate()
+}
+
+// loadWriterPreset returns the named preset from the config
+func loadWriterPreset(name string) (WriterOptions, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return WriterOptions{}, err
+	}
+	opts, ok := config.Writer.Presets[name]
+	if !ok {
+		return WriterOptions{}, fmt.Errorf("unknown writer preset %q", name)
+	}
+	return opts, nil
+}
+
+// validate checks the options against the supported tones, formats and audiences
+func (o WriterOptions) validate() error {
+	checks := []struct {
+		kind    string
+		value   string
+		choices map[string]string
+	}{
+		{"tone", o.Tone, writerTones},
+		{"format", o.Format, writerFormats},
+		{"audience", o.Audience, writerAudiences},
+	}
+	for _, check := range checks {
+		if _, ok := check.choices[check.value]; check.value != "" && !ok {
+			return fmt.Errorf("unknown %s %q, expected one of: %s",
+				check.kind, check.value, strings.Join(optionNames(check.choices), ", "))
+		}
+	}
+	if o.Length < 0 {
+		return fmt.Errorf("length must be a positive number of words")
+	}
+	return nil
+}
+
+// optionNames returns the sorted keys of an option map
+func optionNames(options map[string]string) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyInlineTags strips the legacy [length=X] and [type=...] tags from the
+// text and applies them to options that were not set explicitly
+func applyInlineTags(text string, opts WriterOptions) (string, WriterOptions) {
+	if match := lengthTag.FindStringSubmatch(text); match != nil && opts.Length == 0 {
+		opts.Length, _ = strconv.Atoi(match[1])
+	}
+	if match := typeTag.FindStringSubmatch(text); match != nil && opts.Format == "" {
+		if _, ok := writerFormats[match[1]]; ok {
+			opts.Format = match[1]
+		}
+	}
+	text = lengthTag.ReplaceAllString(text, "")
+	text = typeTag.ReplaceAllString(text, "")
+	return strings.TrimSpace(text), opts
+}
+
+// writerSystemPrompt builds the system prompt for the given options
+func writerSystemPrompt(opts WriterOptions) string {
+	var b strings.Builder
+	b.WriteString("You are an expert editor. Revise the text the user sends you according to the instructions below ")
+	b.WriteString("and return only the revised text, without commentary.\n\n")
+	fmt.Fprintf(&b, "- Tone: %s\n", writerTones[opts.Tone])
+	if opts.Format != "" {
+		fmt.Fprintf(&b, "- Format: %s\n", writerFormats[opts.Format])
+	}
+	if opts.Audience != "" {
+		fmt.Fprintf(&b, "- Audience: %s\n", writerAudiences[opts.Audience])
+	}
+	if opts.Lang != "" {
+		fmt.Fprintf(&b, "- Language: write the output in %s.\n", opts.Lang)
+	} else {
+		b.WriteString("- Language: keep the language of the input.\n")
+	}
+	if opts.Length > 0 {
+		fmt.Fprintf(&b, "- Length: the output must be approximately %d words long.\n", opts.Length)
+	} else if opts.Format == "" {
+		b.WriteString("- Length: keep the original length of the input, within a few words.\n")
+	}
+	if opts.Instructions != "" {
+		fmt.Fprintf(&b, "- Additional instructions: %s\n", opts.Instructions)
+	}
+	b.WriteString("- Keep the meaning, facts, names and numbers of the input unchanged.\n")
+	return b.String()
+}
+
+// checkLength reports whether text satisfies the requested length, and why not
+func checkLength(text string, opts WriterOptions) error {
+	if opts.Format == "tweet" {
+		if n := utf8.RuneCountInString(text); n > 280 {
+			return fmt.Errorf("the tweet is %d characters long, the limit is 280", n)
+		}
+	}
+	if opts.Length > 0 {
+		words := len(strings.Fields(text))
+		tolerance := opts.Length / 5
+		if tolerance < 5 {
+			tolerance = 5
+		}
+		if words < opts.Length-tolerance || words > opts.Length+tolerance {
+			return fmt.Errorf("the text is %d words long, %d were requested", words, opts.Length)
+		}
+	}
+	return nil
+}
+
+// writerModel revises a text with the model for the writer commands. Tests can
+// replace it, like clipboardBackend.
+var writerModel = extractGeminiTextContext
+
+func extractGeminiText(selectedText string, opts WriterOptions) (string, error) {
+	return writerModel(context.Background(), selectedText, opts)
+}
+
+// extractGeminiTextContext is extractGeminiText with a context that can cancel the revision
+func extractGeminiTextContext(ctx context.Context, selectedText string, opts WriterOptions) (string, error) {
+	selectedText, opts = applyInlineTags(selectedText, opts)
+	if opts.Tone == "" {
+		opts.Tone = "professional"
+	}
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+
+	schema := sapiens.Schema{
+		Type: "object",
+		Properties: map[string]sapiens.Schema{
+			"text": {
+				Type:        "string",
+				Description: "The revised text",
+			},
+		},
+		Required: []string{"text"},
+	}
+
+	systemPrompt := writerSystemPrompt(opts)
+	query := selectedText
+
+	// Ask once more if the revision misses the requested length
+	var revised string
+	for attempt := 0; attempt < 2; attempt++ {
+		fields, err := AskStructuredContext(ctx, query, systemPrompt, schema)
+		if err != nil {
+			return "", err
+		}
+		revised = strings.TrimSpace(stringField(fields, "text"))
+		if revised == "" {
+			return "", fmt.Errorf("model returned an empty revision")
+		}
+
+		lengthErr := checkLength(revised, opts)
+		if lengthErr == nil {
+			return revised, nil
+		}
+		if attempt == 1 {
+			color.New(color.FgYellow).Fprintf(os.Stderr, "Warning: %v\n", lengthErr)
+			break
+		}
+		query = fmt.Sprintf("Your previous revision did not meet the length requirement (%v). Revise this text again:\n\n%s",
+			lengthErr, selectedText)
+	}
 
-	// Return the response text, trimming any whitespace
-	return strings.TrimSpace(response.Response), nil
+	return revised, nil
 }
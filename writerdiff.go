@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// TextEdit is a single change between the original and the revised text
+type TextEdit struct {
+	Old       string
+	New       string
+	Rationale string
+}
+
+// diffSegment is either unchanged text or an edit
+type diffSegment struct {
+	Text string
+	Edit *TextEdit
+}
+
+// wordTokens splits text into words, whitespace runs and punctuation
+var wordTokens = regexp.MustCompile(`\s+|[\p{L}\p{N}_'’-]+|[^\s\p{L}\p{N}]`)
+
+var editRationalePrompt = `You are an editor explaining your revision of a text.
+You are given the numbered edits that turned the original text into the revision, as
+"original" -> "revised" pairs. For each major edit (changes of meaning, tone, structure or
+wording that a careful reader would want justified) give a short rationale of at most one
+sentence. Skip trivial edits such as punctuation, capitalization or whitespace.
+
+Reply with a JSON array and nothing else. Each element has the fields:
+  "edit":      the edit number
+  "rationale": the rationale for the edit`
+
+// lineTokens splits text into lines, keeping their line breaks
+var lineTokens = regexp.MustCompile(`[^\n]*\n|[^\n]+`)
+
+// maxDiffCells caps the size of the table diffTokens builds. Larger changes are
+// compared line by line, or shown as a single edit.
+const maxDiffCells = 4000000
+
+// diffWords computes a word-level diff between original and revised text
+func diffWords(original, revised string) []diffSegment {
+	a := wordTokens.FindAllString(original, -1)
+	b := wordTokens.FindAllString(revised, -1)
+
+	// Only the tokens between the common prefix and suffix need to be compared
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var segments []diffSegment
+	if prefix > 0 {
+		segments = append(segments, diffSegment{Text: strings.Join(a[:prefix], "")})
+	}
+	changedA, changedB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(changedA)*len(changedB) > maxDiffCells {
+		changedA = lineTokens.FindAllString(strings.Join(changedA, ""), -1)
+		changedB = lineTokens.FindAllString(strings.Join(changedB, ""), -1)
+	}
+	segments = append(segments, diffTokens(changedA, changedB)...)
+	if suffix > 0 {
+		segments = append(segments, diffSegment{Text: strings.Join(a[len(a)-suffix:], "")})
+	}
+	return mergeEdits(segments)
+}
+
+// diffTokens computes the diff between two token lists
+func diffTokens(a, b []string) []diffSegment {
+	if len(a)*len(b) > maxDiffCells {
+		return []diffSegment{{Edit: &TextEdit{Old: strings.Join(a, ""), New: strings.Join(b, "")}}}
+	}
+
+	// Longest common subsequence table over the tokens
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var segments []diffSegment
+	var equal, removed, added strings.Builder
+	flushEqual := func() {
+		if equal.Len() > 0 {
+			segments = append(segments, diffSegment{Text: equal.String()})
+			equal.Reset()
+		}
+	}
+	flushEdit := func() {
+		if removed.Len() > 0 || added.Len() > 0 {
+			segments = append(segments, diffSegment{Edit: &TextEdit{Old: removed.String(), New: added.String()}})
+			removed.Reset()
+			added.Reset()
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flushEdit()
+			equal.WriteString(a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			flushEqual()
+			added.WriteString(b[j])
+			j++
+		default:
+			flushEqual()
+			removed.WriteString(a[i])
+			i++
+		}
+	}
+	flushEdit()
+	flushEqual()
+	return segments
+}
+
+// mergeEdits joins edits that are separated only by whitespace, so that a
+// rewritten phrase shows up as one edit instead of one per word
+func mergeEdits(segments []diffSegment) []diffSegment {
+	var merged []diffSegment
+	for i := 0; i < len(segments); i++ {
+		segment := segments[i]
+		last := len(merged) - 1
+		if segment.Edit == nil && strings.TrimSpace(segment.Text) == "" &&
+			last >= 0 && merged[last].Edit != nil &&
+			i+1 < len(segments) && segments[i+1].Edit != nil {
+			next := segments[i+1].Edit
+			merged[last].Edit.Old += segment.Text + next.Old
+			merged[last].Edit.New += segment.Text + next.New
+			i++
+			continue
+		}
+		merged = append(merged, segment)
+	}
+	return merged
+}
+
+// diffEdits returns the edits of a diff in order
+func diffEdits(segments []diffSegment) []*TextEdit {
+	var edits []*TextEdit
+	for _, segment := range segments {
+		if segment.Edit != nil {
+			edits = append(edits, segment.Edit)
+		}
+	}
+	return edits
+}
+
+// renderDiff renders the diff with removed text struck through in red and added
+// text in green. Edits with a rationale are numbered.
+func renderDiff(segments []diffSegment) string {
+	removed := color.New(color.FgRed, color.CrossedOut)
+	added := color.New(color.FgGreen, color.Underline)
+	marker := color.New(color.FgCyan)
+
+	var b strings.Builder
+	n := 0
+	for _, segment := range segments {
+		if segment.Edit == nil {
+			b.WriteString(segment.Text)
+			continue
+		}
+		n++
+		if segment.Edit.Old != "" {
+			b.WriteString(removed.Sprint(segment.Edit.Old))
+		}
+		if segment.Edit.New != "" {
+			b.WriteString(added.Sprint(segment.Edit.New))
+		}
+		if segment.Edit.Rationale != "" {
+			b.WriteString(marker.Sprintf("[%d]", n))
+		}
+	}
+	return b.String()
+}
+
+// applyEdits rebuilds the text, keeping the revised side of accepted edits and
+// the original side of rejected ones
+func applyEdits(segments []diffSegment, accepted map[*TextEdit]bool) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		switch {
+		case segment.Edit == nil:
+			b.WriteString(segment.Text)
+		case accepted[segment.Edit]:
+			b.WriteString(segment.Edit.New)
+		default:
+			b.WriteString(segment.Edit.Old)
+		}
+	}
+	return b.String()
+}
+
+// explainEdits asks the AI for a short rationale of the major edits
+func explainEdits(edits []*TextEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+
+	var query strings.Builder
+	for i, edit := range edits {
+		fmt.Fprintf(&query, "%d. %q -> %q\n", i+1, edit.Old, edit.New)
+	}
+
+	var rationales []struct {
+		Edit      int    `json:"edit"`
+		Rationale string `json:"rationale"`
+	}
+	if err := AskJSON(query.String(), editRationalePrompt, &rationales); err != nil {
+		return err
+	}
+
+	for _, r := range rationales {
+		if r.Edit >= 1 && r.Edit <= len(edits) {
+			edits[r.Edit-1].Rationale = strings.TrimSpace(r.Rationale)
+		}
+	}
+	return nil
+}
+
+// printRationales lists the numbered rationales below a rendered diff
+func printRationales(segments []diffSegment) {
+	n := 0
+	printed := false
+	for _, edit := range diffEdits(segments) {
+		n++
+		if edit.Rationale == "" {
+			continue
+		}
+		if !printed {
+			color.New(color.FgCyan, color.Bold).Println("\nMajor changes:")
+			printed = true
+		}
+		fmt.Printf("%s %s\n", color.CyanString("[%d]", n), edit.Rationale)
+	}
+}
+
+// reviewEdits walks through the edits interactively and returns the text with
+// only the accepted edits applied
+func reviewEdits(segments []diffSegment) string {
+	edits := diffEdits(segments)
+	accepted := make(map[*TextEdit]bool)
+	reader := bufio.NewReader(os.Stdin)
+
+	for i, edit := range edits {
+		color.New(color.FgBlue, color.Bold).Printf("\nEdit %d/%d\n", i+1, len(edits))
+		fmt.Printf("  %s %s\n", color.RedString("-"), color.RedString(strings.TrimSpace(edit.Old)))
+		fmt.Printf("  %s %s\n", color.GreenString("+"), color.GreenString(strings.TrimSpace(edit.New)))
+		if edit.Rationale != "" {
+			color.New(color.FgCyan).Printf("  Why: %s\n", edit.Rationale)
+		}
+
+		fmt.Print(color.CyanString("Accept? (y = yes, n = no, a = accept all remaining, q = reject all remaining): "))
+		choice, _ := reader.ReadString('\n')
+
+		switch strings.ToLower(strings.TrimSpace(choice)) {
+		case "y", "yes":
+			accepted[edit] = true
+		case "a", "all":
+			for _, remaining := range edits[i:] {
+				accepted[remaining] = true
+			}
+			return applyEdits(segments, accepted)
+		case "q", "quit":
+			return applyEdits(segments, accepted)
+		}
+	}
+
+	return applyEdits(segments, accepted)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/4nkitd/sapiens"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// LintIssue is a grammar or style problem found in a document
+type LintIssue struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Column     int    `json:"column"`
+	Span       string `json:"span"`
+	Rule       string `json:"rule"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+var lintSystemPrompt = `You are a careful copy editor checking documentation and release notes.
+The user sends a JSON array of Markdown paragraphs. Tokens of the form ⟦n⟧ stand for code,
+links and URLs; never report them. Report grammar, spelling and style problems using these
+rules: "grammar", "spelling", "punctuation", "wordiness", "passive-voice", "clarity",
+"consistency", "tone". Report only real problems, not matters of taste.
+
+Reply with one issue per problem, and no issues if there are none.`
+
+// lintSchema is the response schema of a lint request
+var lintSchema = func() sapiens.Schema {
+	issue := sapiens.Schema{
+		Type: "object",
+		Properties: map[string]sapiens.Schema{
+			"paragraph":  {Type: "integer", Description: "The index of the paragraph in the array, starting at 0"},
+			"span":       {Type: "string", Description: "The exact text of the problem, copied character for character from the paragraph"},
+			"rule":       {Type: "string", Description: "One of the rules of the instructions"},
+			"message":    {Type: "string", Description: "A short explanation of the problem"},
+			"suggestion": {Type: "string", Description: "The replacement text for the span"},
+		},
+		Required: []string{"paragraph", "span", "rule", "message"},
+	}
+	issues := sapiens.Schema{Type: "array", Description: "The problems found"}
+	setSchemaItems(&issues, &issue)
+	return sapiens.Schema{
+		Type:       "object",
+		Properties: map[string]sapiens.Schema{"issues": issues},
+		Required:   []string{"issues"},
+	}
+}()
+
+// errLintIssues is returned by writer lint when it found issues, so that the
+// command exits with status 1 without printing an error
+var errLintIssues = errors.New("lint issues found")
+
+// WriterLintCmd represents the writer lint command
+var WriterLintCmd = &cobra.Command{
+	Use:   "lint <file>...",
+	Short: "Check documents for grammar and style issues",
+	Long: `Checks the prose of text and Markdown files for grammar, spelling and style issues and
+reports them as file:line:col diagnostics, or as JSON with --format json. Code blocks,
+inline code, links and front matter are not checked. Exits with status 1 when issues are
+found, so it can be used in CI.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("unknown format %q, expected text or json", format)
+		}
+
+		issues := []LintIssue{}
+		for _, file := range args {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", file, err)
+			}
+
+			fileIssues, err := LintDocument(file, string(content))
+			if err != nil {
+				return err
+			}
+			issues = append(issues, fileIssues...)
+		}
+
+		if format == "json" {
+			if err := writeJSON(os.Stdout, issues); err != nil {
+				return err
+			}
+		} else {
+			printLintIssues(issues)
+		}
+
+		// A non-zero exit status lets CI fail on issues without extra parsing
+		if len(issues) > 0 {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			return errLintIssues
+		}
+		return nil
+	},
+}
+
+func init() {
+	WriterLintCmd.Flags().String("format", "text", "Output format: text or json")
+	WriterCmd.AddCommand(WriterLintCmd)
+}
+
+// LintDocument checks the prose paragraphs of a document and returns the issues
+// with their positions in the file
+func LintDocument(file, content string) ([]LintIssue, error) {
+	blocks := parseMarkdown(content, true)
+
+	// Offsets of the prose blocks in the document
+	type prose struct {
+		offset int
+		text   string
+		spans  []string
+	}
+	var paragraphs []prose
+	offset := 0
+	for _, block := range blocks {
+		if block.Prose {
+			paragraphs = append(paragraphs, prose{offset: offset, text: block.Text})
+		}
+		offset += len(block.Text)
+	}
+
+	var issues []LintIssue
+	for start := 0; start < len(paragraphs); {
+		// Build a chunk of paragraphs within the size budget
+		end, size := start, 0
+		for end < len(paragraphs) && (end == start || size+len(paragraphs[end].text) <= markdownChunkSize) {
+			size += len(paragraphs[end].text)
+			end++
+		}
+
+		chunk := paragraphs[start:end]
+		protected := make([]string, len(chunk))
+		for i := range chunk {
+			protected[i], chunk[i].spans = protectMarkdown(chunk[i].text)
+		}
+
+		query, err := json.Marshal(protected)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := AskStructuredContext(context.Background(), string(query), lintSystemPrompt, lintSchema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lint %s: %w", file, err)
+		}
+		var found struct {
+			Issues []struct {
+				Paragraph  int    `json:"paragraph"`
+				Span       string `json:"span"`
+				Rule       string `json:"rule"`
+				Message    string `json:"message"`
+				Suggestion string `json:"suggestion"`
+			} `json:"issues"`
+		}
+		encoded, _ := json.Marshal(fields)
+		if err := json.Unmarshal(encoded, &found); err != nil {
+			return nil, fmt.Errorf("failed to lint %s: model returned malformed issues: %w", file, err)
+		}
+
+		for _, f := range found.Issues {
+			if f.Paragraph < 0 || f.Paragraph >= len(chunk) || f.Span == "" {
+				continue
+			}
+			paragraph := chunk[f.Paragraph]
+
+			// Locate the span in the original text; skip issues the model made up
+			span := expandMarkdownTokens(f.Span, paragraph.spans)
+			index := strings.Index(paragraph.text, span)
+			if index < 0 {
+				continue
+			}
+
+			line, column := lineColumn(content, paragraph.offset+index)
+			issues = append(issues, LintIssue{
+				File:       file,
+				Line:       line,
+				Column:     column,
+				Span:       span,
+				Rule:       f.Rule,
+				Message:    f.Message,
+				Suggestion: expandMarkdownTokens(f.Suggestion, paragraph.spans),
+			})
+		}
+
+		start = end
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+	return issues, nil
+}
+
+// lineColumn converts a byte offset into a 1-based line and column (in characters)
+func lineColumn(content string, offset int) (int, int) {
+	before := content[:offset]
+	line := strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndex(before, "\n") + 1
+	return line, utf8.RuneCountInString(before[lineStart:]) + 1
+}
+
+// printLintIssues prints the issues in the file:line:col format understood by
+// editors and CI annotations
+func printLintIssues(issues []LintIssue) {
+	for _, issue := range issues {
+		fmt.Printf("%s:%d:%d: %s: %s\n",
+			issue.File, issue.Line, issue.Column, color.YellowString(issue.Rule), issue.Message)
+		if issue.Suggestion != "" {
+			fmt.Printf("    %s %s\n", color.RedString(issue.Span), color.GreenString("→ "+issue.Suggestion))
+		}
+	}
+
+	if len(issues) == 0 {
+		color.New(color.FgGreen, color.Bold).Println("✓ No issues found")
+		return
+	}
+	fmt.Printf("\n%d issue(s) found\n", len(issues))
+}